@@ -0,0 +1,60 @@
+// Code generated by mvcgen. DO NOT EDIT.
+
+package main
+
+import (
+	"pointTest/tcpProxy/tcpmvc"
+)
+
+// 调用对端tcpWorker的方法，由mvcgen根据main包中的tcpWorker生成
+type backendClient struct {
+	Mvc *tcpmvc.Mvc
+}
+
+// 创建发往tcpWorker.HttpRequest的Data，需要设置截止时间或Meta时使用
+func (c backendClient) NewHttpRequest(args map[string][]byte) *tcpmvc.Data {
+	data := tcpmvc.NewData()
+	data.Model = "tcpWorker"
+	data.Method = "HttpRequest"
+	if args != nil {
+		data.Args = args
+	}
+	return data
+}
+
+// 发送tcpWorker.HttpRequest，不等待处理结果
+func (c backendClient) HttpRequest(args map[string][]byte) error {
+	return c.Mvc.Write(c.NewHttpRequest(args))
+}
+
+// 创建发往tcpWorker.Message的Data，需要设置截止时间或Meta时使用
+func (c backendClient) NewMessage(args map[string][]byte) *tcpmvc.Data {
+	data := tcpmvc.NewData()
+	data.Model = "tcpWorker"
+	data.Method = "Message"
+	if args != nil {
+		data.Args = args
+	}
+	return data
+}
+
+// 发送tcpWorker.Message，不等待处理结果
+func (c backendClient) Message(args map[string][]byte) error {
+	return c.Mvc.Write(c.NewMessage(args))
+}
+
+// 创建发往tcpWorker.Shutdown的Data，需要设置截止时间或Meta时使用
+func (c backendClient) NewShutdown(args map[string][]byte) *tcpmvc.Data {
+	data := tcpmvc.NewData()
+	data.Model = "tcpWorker"
+	data.Method = "Shutdown"
+	if args != nil {
+		data.Args = args
+	}
+	return data
+}
+
+// 发送tcpWorker.Shutdown，不等待处理结果
+func (c backendClient) Shutdown(args map[string][]byte) error {
+	return c.Mvc.Write(c.NewShutdown(args))
+}
@@ -1,11 +1,11 @@
-package chat
-
-import (
-	"fmt"
-)
-
-func Funca() {
-	a := 12
-	fmt.Printf("5d\n", a)
-	fmt.Println("aa")
-}
+package chat
+
+import (
+	"fmt"
+)
+
+func Funca() {
+	a := 12
+	fmt.Printf("%5d\n", a)
+	fmt.Println("aa")
+}
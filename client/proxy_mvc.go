@@ -0,0 +1,117 @@
+// Code generated by mvcgen. DO NOT EDIT.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"pointTest/tcpProxy/tcpmvc"
+)
+
+// 调用对端tcpWorker的方法，由mvcgen根据main包中的tcpWorker生成
+type proxyClient struct {
+	Mvc *tcpmvc.Mvc
+}
+
+// 创建发往tcpWorker.HttpResponse的Data，需要设置截止时间或Meta时使用
+func (c proxyClient) NewHttpResponse(args map[string][]byte) *tcpmvc.Data {
+	data := tcpmvc.NewData()
+	data.Model = "tcpWorker"
+	data.Method = "HttpResponse"
+	if args != nil {
+		data.Args = args
+	}
+	return data
+}
+
+// 发送tcpWorker.HttpResponse，不等待处理结果
+func (c proxyClient) HttpResponse(args map[string][]byte) error {
+	return c.Mvc.Write(c.NewHttpResponse(args))
+}
+
+// 创建发往tcpWorker.ListRegistrations的Data，需要设置截止时间或Meta时使用
+func (c proxyClient) NewListRegistrations(args map[string][]byte) *tcpmvc.Data {
+	data := tcpmvc.NewData()
+	data.Model = "tcpWorker"
+	data.Method = "ListRegistrations"
+	if args != nil {
+		data.Args = args
+	}
+	return data
+}
+
+// 发送tcpWorker.ListRegistrations，不等待处理结果
+func (c proxyClient) ListRegistrations(args map[string][]byte) error {
+	return c.Mvc.Write(c.NewListRegistrations(args))
+}
+
+// 调用tcpWorker.ListRegistrations并等待返回值
+func (c proxyClient) CallListRegistrations(ctx context.Context, args map[string][]byte) ([]string, error) {
+	var result []string
+	raw, err := c.Mvc.Call(ctx, c.NewListRegistrations(args))
+	if err != nil {
+		return result, err
+	}
+	err = json.Unmarshal(raw, &result)
+	return result, err
+}
+
+// 创建发往tcpWorker.Message的Data，需要设置截止时间或Meta时使用
+func (c proxyClient) NewMessage(args map[string][]byte) *tcpmvc.Data {
+	data := tcpmvc.NewData()
+	data.Model = "tcpWorker"
+	data.Method = "Message"
+	if args != nil {
+		data.Args = args
+	}
+	return data
+}
+
+// 发送tcpWorker.Message，不等待处理结果
+func (c proxyClient) Message(args map[string][]byte) error {
+	return c.Mvc.Write(c.NewMessage(args))
+}
+
+// 创建发往tcpWorker.Register的Data，需要设置截止时间或Meta时使用
+func (c proxyClient) NewRegister(args map[string][]byte) *tcpmvc.Data {
+	data := tcpmvc.NewData()
+	data.Model = "tcpWorker"
+	data.Method = "Register"
+	if args != nil {
+		data.Args = args
+	}
+	return data
+}
+
+// 发送tcpWorker.Register，不等待处理结果
+func (c proxyClient) Register(args map[string][]byte) error {
+	return c.Mvc.Write(c.NewRegister(args))
+}
+
+// 调用tcpWorker.Register并等待返回值
+func (c proxyClient) CallRegister(ctx context.Context, args map[string][]byte) error {
+	_, err := c.Mvc.Call(ctx, c.NewRegister(args))
+	return err
+}
+
+// 创建发往tcpWorker.Unregister的Data，需要设置截止时间或Meta时使用
+func (c proxyClient) NewUnregister(args map[string][]byte) *tcpmvc.Data {
+	data := tcpmvc.NewData()
+	data.Model = "tcpWorker"
+	data.Method = "Unregister"
+	if args != nil {
+		data.Args = args
+	}
+	return data
+}
+
+// 发送tcpWorker.Unregister，不等待处理结果
+func (c proxyClient) Unregister(args map[string][]byte) error {
+	return c.Mvc.Write(c.NewUnregister(args))
+}
+
+// 调用tcpWorker.Unregister并等待返回值
+func (c proxyClient) CallUnregister(ctx context.Context, args map[string][]byte) error {
+	_, err := c.Mvc.Call(ctx, c.NewUnregister(args))
+	return err
+}
@@ -0,0 +1,23 @@
+// Code generated by mvcgen. DO NOT EDIT.
+
+package main
+
+import (
+	"pointTest/tcpProxy/tcpmvc"
+)
+
+// tcpWorker可由消息调用的方法，方法改名或删除后下面的断言无法编译，需重新运行go generate
+type tcpWorkerServer interface {
+	HttpRequest(*tcpmvc.Data)
+	Message(map[string][]byte)
+	Shutdown(map[string][]byte)
+}
+
+var _ tcpWorkerServer = (*tcpWorker)(nil)
+
+// 直接注册tcpWorker可由消息调用的方法，不经过反射，Include时自动调用
+func (impl *tcpWorker) RegisterHandlers(m *tcpmvc.Mvc) {
+	m.HandleData("tcpWorker", "HttpRequest", impl.HttpRequest)
+	m.Handle("tcpWorker", "Message", impl.Message)
+	m.Handle("tcpWorker", "Shutdown", impl.Shutdown)
+}
@@ -0,0 +1,376 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//代理服务器的配置，从-config指定的JSON文件读取，命令行中显式给出的参数覆盖文件中的值
+//示例见tcpProxy.example.json；收到SIGHUP或控制台输入reload时重新读取，监听地址、TLS与日志文件等需要重启才能生效
+type Config struct {
+	TCPAddr     string   `json:"tcp_addr"`     //后端TCP连接的监听地址
+	HTTPAddr    string   `json:"http_addr"`    //用户HTTP请求与WebSocket隧道的监听地址
+	UnixPath    string   `json:"unix_path"`    //同时在该Unix socket上接受本机后端的连接，为空时不监听
+	UnixPerm    FileMode `json:"unix_perm"`    //Unix socket文件的权限，如"0660"
+	WSPath      string   `json:"ws_path"`      //在HTTP端口上接受WebSocket隧道连接的路径，为空时不接受
+	GatewayAddr string   `json:"gateway_addr"` //调试网关监听地址，为空时不启动
+
+	ErrorLog   string `json:"error_log"`   //错误日志文件路径
+	AccessLog  string `json:"access_log"`  //访问日志文件路径，为空时不记录
+	CaptureLog string `json:"capture_log"` //抓包文件路径，为空时不记录
+	Trace      string `json:"trace"`       //追踪导出目标：stdout或OTLP/HTTP地址，为空时不追踪
+
+	RequestTimeout  Duration `json:"request_timeout"`  //等待后端返回响应头的最长时间，如"30s"，为0时不限
+	ShutdownTimeout Duration `json:"shutdown_timeout"` //关闭时等待进行中的请求完成与后端断开的最长时间
+	LogLevel        string   `json:"log_level"`        //控制台输出级别：debug输出每个请求，info输出连接变化，error只输出错误
+
+	TLS     TLSConfig                `json:"tls"`
+	Auth    AuthConfig               `json:"auth"`
+	Domains map[string]*DomainConfig `json:"domains"` //按域名的设置，键为请求的Host
+}
+
+//HTTP监听的TLS证书，两项都设置时以HTTPS提供服务，WebSocket隧道随之为wss
+type TLSConfig struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+}
+
+//后端注册域名时需要提供的token，为空时不校验
+type AuthConfig struct {
+	Tokens []string `json:"tokens"`
+}
+
+//单个域名的设置，零值表示使用全局设置
+type DomainConfig struct {
+	RequestTimeout Duration `json:"request_timeout"` //覆盖全局的request_timeout
+	Tokens         []string `json:"tokens"`          //只接受持有这些token的后端注册，覆盖auth.tokens
+	MaxBackends    int      `json:"max_backends"`    //同时注册的后端数量上限，0不限
+}
+
+//默认配置，与没有配置文件时的行为相同
+func DefaultConfig() *Config {
+	return &Config{
+		TCPAddr:         "127.0.0.1:7000",
+		HTTPAddr:        "127.0.0.1:7100",
+		WSPath:          "/_tunnel",
+		ErrorLog:        "error.log",
+		RequestTimeout:  Duration(30 * time.Second),
+		ShutdownTimeout: Duration(30 * time.Second),
+		LogLevel:        "debug",
+	}
+}
+
+//把配置项绑定到命令行参数
+func (c *Config) bindFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.TCPAddr, "tcp", c.TCPAddr, "后端TCP连接的监听地址")
+	fs.StringVar(&c.HTTPAddr, "http", c.HTTPAddr, "用户HTTP请求与WebSocket隧道的监听地址")
+	fs.StringVar(&c.UnixPath, "unix", c.UnixPath, "同时在该Unix socket上接受本机后端的连接，为空时不监听")
+	fs.Var(&c.UnixPerm, "unix-perm", "Unix socket文件的权限(八进制)，默认0660")
+	fs.StringVar(&c.WSPath, "ws-path", c.WSPath, "在HTTP端口上接受WebSocket隧道连接的路径，为空时只接受TCP连接")
+	fs.StringVar(&c.GatewayAddr, "gateway", c.GatewayAddr, "调试网关监听地址，POST /{域名}/{model}/{method}调用该域名后端的方法，为空时不启动")
+	fs.StringVar(&c.ErrorLog, "error-log", c.ErrorLog, "错误日志文件路径")
+	fs.StringVar(&c.AccessLog, "access-log", c.AccessLog, "访问日志文件路径，为空时不记录")
+	fs.StringVar(&c.CaptureLog, "capture", c.CaptureLog, "抓包文件路径，记录与后端收发的每一帧，为空时不记录")
+	fs.StringVar(&c.Trace, "trace", c.Trace, "导出追踪span：stdout或OTLP/HTTP地址(如http://127.0.0.1:4318/v1/traces)，为空时不追踪")
+	fs.Var(&c.RequestTimeout, "timeout", "等待后端返回响应头的最长时间，超时返回504，为0时不限(默认30s)")
+	fs.Var(&c.ShutdownTimeout, "shutdown-timeout", "关闭时等待进行中的请求完成与后端断开的最长时间(默认30s)")
+	fs.StringVar(&c.LogLevel, "log-level", c.LogLevel, "控制台输出级别：debug、info或error")
+	fs.StringVar(&c.TLS.CertFile, "tls-cert", c.TLS.CertFile, "HTTP监听的TLS证书文件")
+	fs.StringVar(&c.TLS.KeyFile, "tls-key", c.TLS.KeyFile, "HTTP监听的TLS私钥文件")
+}
+
+//读取配置文件，再应用命令行中显式给出的参数并检查，path为空时只使用命令行参数
+func loadConfig(path string, overrides map[string]string) (*Config, error) {
+	c := DefaultConfig()
+	if path != "" {
+		if err := c.readFile(path); err != nil {
+			return nil, err
+		}
+	}
+	fs := flag.NewFlagSet("config", flag.ContinueOnError)
+	c.bindFlags(fs)
+	for name, value := range overrides {
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("参数-%s:%s", name, err.Error())
+		}
+	}
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//读取JSON配置文件，未知的配置项视为错误
+func (c *Config) readFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败:%s", err.Error())
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		var serr *json.SyntaxError
+		var terr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &serr):
+			return fmt.Errorf("%s:%d: 配置文件格式错误:%s", path, lineOf(b, serr.Offset), serr.Error())
+		case errors.As(err, &terr):
+			return fmt.Errorf("%s:%d: 配置项%s应为%s", path, lineOf(b, terr.Offset), terr.Field, terr.Type.String())
+		}
+		if name := strings.TrimPrefix(err.Error(), "json: unknown field "); name != err.Error() {
+			return fmt.Errorf("%s: 未知的配置项%s", path, name)
+		}
+		return fmt.Errorf("%s: %s", path, err.Error())
+	}
+	return nil
+}
+
+//偏移所在的行号
+func lineOf(b []byte, offset int64) int {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	return bytes.Count(b[:offset], []byte("\n")) + 1
+}
+
+//检查配置，列出所有问题
+func (c *Config) Validate() error {
+	var msgs []string
+	fail := func(format string, a ...interface{}) {
+		msgs = append(msgs, fmt.Sprintf(format, a...))
+	}
+	checkAddr := func(name, addr string, required bool) {
+		if addr == "" {
+			if required {
+				fail("%s: 不能为空", name)
+			}
+			return
+		}
+		_, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			fail("%s: 地址%q无效，应为host:port", name, addr)
+			return
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 0 || n > 65535 {
+			fail("%s: 端口%q无效", name, port)
+		}
+	}
+	checkAddr("tcp_addr", c.TCPAddr, true)
+	checkAddr("http_addr", c.HTTPAddr, true)
+	checkAddr("gateway_addr", c.GatewayAddr, false)
+	if c.WSPath != "" && !strings.HasPrefix(c.WSPath, "/") {
+		fail("ws_path: %q应以/开头", c.WSPath)
+	}
+	if c.ErrorLog == "" {
+		fail("error_log: 不能为空")
+	}
+	if c.Trace != "" && c.Trace != "stdout" {
+		if u, err := url.Parse(c.Trace); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fail("trace: %q应为stdout或http(s)地址", c.Trace)
+		}
+	}
+	if c.RequestTimeout < 0 {
+		fail("request_timeout: 不能为负数")
+	}
+	if c.ShutdownTimeout <= 0 {
+		fail("shutdown_timeout: 应大于0")
+	}
+	if _, ok := logLevels[c.LogLevel]; !ok {
+		fail("log_level: %q应为debug、info或error", c.LogLevel)
+	}
+	if (c.TLS.CertFile == "") != (c.TLS.KeyFile == "") {
+		fail("tls: cert_file与key_file需要同时设置")
+	} else if c.TLS.CertFile != "" {
+		if _, err := tls.LoadX509KeyPair(c.TLS.CertFile, c.TLS.KeyFile); err != nil {
+			fail("tls: 加载证书失败:%s", err.Error())
+		}
+	}
+	for i, t := range c.Auth.Tokens {
+		if t == "" {
+			fail("auth.tokens[%d]: 不能为空", i)
+		}
+	}
+	for domain, d := range c.Domains {
+		name := "domains[" + strconv.Quote(domain) + "]"
+		if domain == "" || strings.ContainsAny(domain, "/ ") {
+			fail("%s: 应为请求的Host，如example.com或127.0.0.1:7100", name)
+		}
+		if d == nil {
+			fail("%s: 不能为null", name)
+			continue
+		}
+		if d.RequestTimeout < 0 {
+			fail("%s.request_timeout: 不能为负数", name)
+		}
+		if d.MaxBackends < 0 {
+			fail("%s.max_backends: 不能为负数", name)
+		}
+		for i, t := range d.Tokens {
+			if t == "" {
+				fail("%s.tokens[%d]: 不能为空", name, i)
+			}
+		}
+	}
+	if len(msgs) == 0 {
+		return nil
+	}
+	return errors.New("配置无效:\n  " + strings.Join(msgs, "\n  "))
+}
+
+//控制台输出级别
+const (
+	levelDebug = iota
+	levelInfo
+	levelError
+)
+
+var logLevels = map[string]int{"debug": levelDebug, "info": levelInfo, "error": levelError}
+
+//是否输出该级别的信息
+func (c *Config) logs(level int) bool {
+	return level >= logLevels[c.LogLevel]
+}
+
+//保留old中需要重启才能生效的配置项，返回被保留的配置项名称
+func (c *Config) keepStatic(old *Config) []string {
+	var kept []string
+	keep := func(name string, cur, prev interface{}) bool {
+		if reflect.DeepEqual(cur, prev) {
+			return false
+		}
+		kept = append(kept, name)
+		return true
+	}
+	if keep("tcp_addr", c.TCPAddr, old.TCPAddr) {
+		c.TCPAddr = old.TCPAddr
+	}
+	if keep("http_addr", c.HTTPAddr, old.HTTPAddr) {
+		c.HTTPAddr = old.HTTPAddr
+	}
+	if keep("unix_path", c.UnixPath, old.UnixPath) {
+		c.UnixPath = old.UnixPath
+	}
+	if keep("unix_perm", c.UnixPerm, old.UnixPerm) {
+		c.UnixPerm = old.UnixPerm
+	}
+	if keep("gateway_addr", c.GatewayAddr, old.GatewayAddr) {
+		c.GatewayAddr = old.GatewayAddr
+	}
+	if keep("error_log", c.ErrorLog, old.ErrorLog) {
+		c.ErrorLog = old.ErrorLog
+	}
+	if keep("access_log", c.AccessLog, old.AccessLog) {
+		c.AccessLog = old.AccessLog
+	}
+	if keep("capture_log", c.CaptureLog, old.CaptureLog) {
+		c.CaptureLog = old.CaptureLog
+	}
+	if keep("trace", c.Trace, old.Trace) {
+		c.Trace = old.Trace
+	}
+	if keep("tls", c.TLS, old.TLS) {
+		c.TLS = old.TLS
+	}
+	return kept
+}
+
+//域名的设置，没有单独设置时返回nil
+func (c *Config) domain(host string) *DomainConfig {
+	return c.Domains[host]
+}
+
+//等待域名的后端返回响应头的最长时间
+func (c *Config) requestTimeout(host string) time.Duration {
+	if d := c.domain(host); d != nil && d.RequestTimeout != 0 {
+		return time.Duration(d.RequestTimeout)
+	}
+	return time.Duration(c.RequestTimeout)
+}
+
+//后端是否可以用token注册域名，域名设置了tokens时只按域名的tokens校验
+func (c *Config) allowToken(host, token string) bool {
+	tokens := c.Auth.Tokens
+	if d := c.domain(host); d != nil && len(d.Tokens) > 0 {
+		tokens = d.Tokens
+	}
+	if len(tokens) == 0 {
+		return true
+	}
+	for _, t := range tokens {
+		if t == token {
+			return true
+		}
+	}
+	return false
+}
+
+//JSON中写作"30s"、"1m30s"的时长，也可用作命令行参数
+type Duration time.Duration
+
+func (d Duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *Duration) Set(s string) error {
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("时长应为字符串，如\"30s\"")
+	}
+	return d.Set(s)
+}
+
+//JSON中写作"0660"的八进制文件权限，也可用作命令行参数
+type FileMode os.FileMode
+
+func (m FileMode) String() string {
+	if m == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%#o", uint32(m))
+}
+
+func (m *FileMode) Set(s string) error {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil || v > 0777 {
+		return errors.New("权限应为0000-0777的八进制数")
+	}
+	*m = FileMode(v)
+	return nil
+}
+
+func (m FileMode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.String())
+}
+
+func (m *FileMode) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return errors.New("权限应为字符串，如\"0660\"")
+	}
+	return m.Set(s)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+//在临时目录中写入配置文件
+func writeConfig(t *testing.T, body string) string {
+	dir, err := ioutil.TempDir("", "tcpProxy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "tcpProxy.json")
+	if err := ioutil.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	path := writeConfig(t, `{
+	"tcp_addr": "0.0.0.0:7000",
+	"unix_perm": "0600",
+	"request_timeout": "5s",
+	"log_level": "info",
+	"auth": {"tokens": ["t1"]},
+	"domains": {"a.test": {"request_timeout": "1m", "tokens": ["ta"], "max_backends": 2}}
+}`)
+	c, err := loadConfig(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TCPAddr != "0.0.0.0:7000" || c.UnixPerm != 0600 || c.LogLevel != "info" {
+		t.Fatalf("config %+v", c)
+	}
+	//文件中没有的配置项使用默认值
+	if c.HTTPAddr != DefaultConfig().HTTPAddr || c.ShutdownTimeout != DefaultConfig().ShutdownTimeout {
+		t.Fatalf("defaults not kept: %+v", c)
+	}
+	if c.requestTimeout("a.test") != time.Minute || c.requestTimeout("b.test") != 5*time.Second {
+		t.Fatalf("request timeout %s %s", c.requestTimeout("a.test"), c.requestTimeout("b.test"))
+	}
+	if !c.allowToken("a.test", "ta") || c.allowToken("a.test", "t1") || !c.allowToken("b.test", "t1") {
+		t.Fatal("tokens")
+	}
+
+	//命令行参数优先于配置文件
+	c, err = loadConfig(path, map[string]string{"tcp": "127.0.0.1:7001", "timeout": "0s", "unix-perm": "0640"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.TCPAddr != "127.0.0.1:7001" || c.RequestTimeout != 0 || c.UnixPerm != 0640 || c.LogLevel != "info" {
+		t.Fatalf("overrides %+v", c)
+	}
+
+	//没有配置文件时只使用默认值与命令行参数
+	c, err = loadConfig("", map[string]string{"log-level": "error"})
+	if err != nil || c.LogLevel != "error" || c.TCPAddr != DefaultConfig().TCPAddr {
+		t.Fatalf("without file %+v %v", c, err)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, c := range []struct {
+		name      string
+		body      string
+		overrides map[string]string
+		want      []string
+	}{
+		{"unknown field", `{"tcp_adr": "127.0.0.1:7000"}`, nil, []string{`未知的配置项"tcp_adr"`}},
+		{"syntax", "{\n\"tcp_addr\": \"x\",\n}", nil, []string{":3: 配置文件格式错误"}},
+		{"type", "{\n\"auth\": {\"tokens\": \"t\"}}", nil, []string{":2: 配置项auth.tokens应为[]string"}},
+		{"duration", `{"request_timeout": "5 seconds"}`, nil, []string{`duration "5 seconds"`}},
+		{"duration number", `{"shutdown_timeout": 30}`, nil, []string{`时长应为字符串`}},
+		{"file mode", `{"unix_perm": "0999"}`, nil, []string{"权限应为0000-0777的八进制数"}},
+		{"address", `{"tcp_addr": "7000"}`, nil, []string{`tcp_addr: 地址"7000"无效`}},
+		{"port", `{"http_addr": "127.0.0.1:70000"}`, nil, []string{`http_addr: 端口"70000"无效`}},
+		{"override", `{}`, map[string]string{"shutdown-timeout": "soon"}, []string{"参数-shutdown-timeout:"}},
+		{"override validated", `{}`, map[string]string{"log-level": "trace"}, []string{`log_level: "trace"应为debug、info或error`}},
+		{"aggregated", `{
+	"tcp_addr": "",
+	"ws_path": "tunnel",
+	"trace": "ftp://collector",
+	"shutdown_timeout": "0s",
+	"tls": {"cert_file": "cert.pem"},
+	"auth": {"tokens": [""]},
+	"domains": {"a/b": {"max_backends": -1, "tokens": [""]}, "c.test": null}
+}`, nil, []string{
+			"配置无效:\n  ",
+			"tcp_addr: 不能为空",
+			`ws_path: "tunnel"应以/开头`,
+			`trace: "ftp://collector"应为stdout或http(s)地址`,
+			"shutdown_timeout: 应大于0",
+			"tls: cert_file与key_file需要同时设置",
+			"auth.tokens[0]: 不能为空",
+			`domains["a/b"]: 应为请求的Host`,
+			`domains["a/b"].max_backends: 不能为负数`,
+			`domains["a/b"].tokens[0]: 不能为空`,
+			`domains["c.test"]: 不能为null`,
+		}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := loadConfig(writeConfig(t, c.body), c.overrides)
+			if err == nil {
+				t.Fatal("invalid config accepted")
+			}
+			for _, want := range c.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Fatalf("error missing %q:\n%s", want, err)
+				}
+			}
+		})
+	}
+	if _, err := loadConfig(filepath.Join(os.TempDir(), "tcpProxy-missing.json"), nil); err == nil || !strings.Contains(err.Error(), "读取配置文件失败") {
+		t.Fatalf("missing file: %v", err)
+	}
+}
+
+func TestKeepStatic(t *testing.T) {
+	old := DefaultConfig()
+	c := DefaultConfig()
+	if kept := c.keepStatic(old); len(kept) != 0 {
+		t.Fatalf("unchanged config kept %v", kept)
+	}
+	c.TCPAddr = "127.0.0.1:7001"
+	c.TLS.CertFile = "cert.pem"
+	c.UnixPerm = 0600
+	c.RequestTimeout = Duration(time.Second)
+	c.LogLevel = "error"
+	kept := c.keepStatic(old)
+	if strings.Join(kept, ",") != "tcp_addr,unix_perm,tls" {
+		t.Fatalf("kept %v", kept)
+	}
+	if c.TCPAddr != old.TCPAddr || c.TLS != old.TLS || c.UnixPerm != old.UnixPerm {
+		t.Fatalf("static fields not restored: %+v", c)
+	}
+	if c.RequestTimeout != Duration(time.Second) || c.LogLevel != "error" {
+		t.Fatalf("dynamic fields lost: %+v", c)
+	}
+}
+
+//新配置无效时整体保留原配置，有效时替换，需要重启的配置项保留原值，命令行参数仍然优先
+func TestReload(t *testing.T) {
+	path := writeConfig(t, `{"request_timeout": "5s", "log_level": "info"}`)
+	overrides := map[string]string{"log-level": "error"}
+	config, err := loadConfig(path, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &ProxyServer{config: config, configPath: path, overrides: overrides}
+
+	if err := ioutil.WriteFile(path, []byte(`{"request_timeout": "1s", "shutdown_timeout": "-1s"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.reload(); err == nil || !strings.Contains(err.Error(), "shutdown_timeout") {
+		t.Fatalf("invalid reload: %v", err)
+	}
+	if p.Config() != config || config.RequestTimeout != Duration(5*time.Second) {
+		t.Fatalf("invalid reload changed the config: %+v", p.Config())
+	}
+
+	if err := ioutil.WriteFile(path, []byte(`{
+	"tcp_addr": "127.0.0.1:7001",
+	"request_timeout": "1s",
+	"log_level": "debug",
+	"domains": {"a.test": {"max_backends": 1}}
+}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.reload(); err != nil {
+		t.Fatal(err)
+	}
+	c := p.Config()
+	if c == config {
+		t.Fatal("config not replaced")
+	}
+	if c.RequestTimeout != Duration(time.Second) || c.domain("a.test") == nil || c.domain("a.test").MaxBackends != 1 {
+		t.Fatalf("reloaded config %+v", c)
+	}
+	if c.TCPAddr != config.TCPAddr || c.LogLevel != "error" {
+		t.Fatalf("static field or override lost: %+v", c)
+	}
+	//已发布的Config不被修改
+	if config.RequestTimeout != Duration(5*time.Second) || config.Domains != nil {
+		t.Fatalf("old config modified: %+v", config)
+	}
+}
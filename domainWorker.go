@@ -1,124 +1,242 @@
-package main
-
-import (
-	"bufio"
-	"bytes"
-	"encoding/binary"
-	"fmt"
-	"io/ioutil"
-	"net/http"
-	"net/http/httputil"
-	"pointTest/tcpProxy/tcpmvc"
-	"sync"
-)
-
-//处理代理服务的具体对象，
-//管理与后端服务器的链接,将HTTP请求转为TCP请求与后端应用服务器交换数据
-type domainWorker struct {
-	domain      string                            //对应的域名
-	tcpW        *tcpWorker                        //对应的tcpWorker
-	respWriters map[uint32]chan map[string][]byte //等待返回的HTTP请求
-	requestId   uint32                            //请求id标识
-	mu          sync.Mutex
-}
-
-//向用户返回HTTP请求结果
-func (p *domainWorker) httpResponse(args map[string][]byte) {
-
-	requestIdByte, ok := args["requestId"]
-	if !ok {
-		fmt.Println("domainWorker-httpResponse:args索引requestId无法找到.")
-		return
-	}
-	requestId := binary.LittleEndian.Uint32(requestIdByte)
-	ch, ok := p.respWriters[requestId]
-	if !ok {
-		fmt.Println("domainWorker-httpResponse:respWriters索引" + string(requestIdByte) + "无法找到.")
-		return
-	}
-	ch <- args
-	return
-}
-
-//来自用户的HTTP请求
-func (p *domainWorker) httpHandleFunc(w http.ResponseWriter, r *http.Request) {
-	if p.tcpW.conn == nil {
-		fmt.Println("domainWorker-httpHandleFunc:p.tcpW.conn")
-		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		return
-	}
-	reqBytes, err := httputil.DumpRequest(r, true)
-	if err != nil {
-		fmt.Println("domainWorker-httpHandleFunc:httputil.DumpRequest = err")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	//向应用服务器发送HTTP处理请求
-	data := tcpmvc.NewData()
-	data.Model = "tcpWorker"
-	data.Method = "HttpRequest"
-	data.Args["domain"] = []byte(r.Host)
-	data.Args["request"] = reqBytes
-	requestId := p.getRequestId()
-	data.Args["requestId"] = make([]byte, 4)
-	binary.LittleEndian.PutUint32(data.Args["requestId"], requestId)
-	err = p.tcpW.tmvc.Write(data)
-	if err != nil {
-		fmt.Println("domainWorker-httpHandleFunc:p.tcpW.tmvc.Write = " + err.Error())
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	ch := make(chan map[string][]byte)
-	p.respWriters[requestId] = ch
-	select {
-	case args := <-ch:
-		status, ok := args["status"]
-		if !ok {
-			fmt.Println("domainWorker-httpResponse:args索引status无法找到.")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
-		if string(status) != "200" {
-			fmt.Println("status != 200")
-			http.Error(w, string(args["msg"]), http.StatusNotFound)
-			return
-		}
-		responseByte, ok := args["resp"]
-		if !ok {
-			fmt.Println("domainWorker-httpResponse:args索引resp无法找到.")
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-			return
-		}
-		byteReader := bytes.NewReader(responseByte)
-		bufioReader := bufio.NewReader(byteReader)
-		response, err := http.ReadResponse(bufioReader, nil)
-		bodyBytes, err := ioutil.ReadAll(response.Body)
-		if err != nil {
-			return
-		}
-		for k, v := range response.Header {
-			for _, v2 := range v {
-				w.Header().Add(k, v2)
-			}
-		}
-		w.WriteHeader(response.StatusCode)
-		wLength, err := w.Write(bodyBytes)
-		if err != nil {
-			return
-		}
-		if wLength != len(bodyBytes) {
-			fmt.Println("写入数据不完整")
-		}
-	}
-	delete(p.respWriters, requestId)
-	close(ch)
-	fmt.Printf("count domainWorker.respWriters %d\n", len(p.respWriters))
-	return
-}
-
-func (p *domainWorker) getRequestId() uint32 {
-	p.mu.Lock()
-	p.requestId++
-	p.mu.Unlock()
-	return p.requestId
-}
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"pointTest/tcpProxy/tcpmvc"
+	"pointTest/tcpProxy/tcpmvc/mvctrace"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//处理代理服务的具体对象，
+//管理与后端服务器的链接,将HTTP请求转为TCP请求与后端应用服务器交换数据
+type domainWorker struct {
+	domain      string                            //对应的域名
+	tcpW        *tcpWorker                        //对应的tcpWorker
+	registry    *Registry                         //所在的注册表
+	respWriters map[uint32]chan map[string][]byte //等待返回的HTTP请求
+	requestId   uint32                            //请求id标识
+	pending     int                               //进行中的httpHandleFunc
+	retired     bool                              //域名已注销，不再接受新的请求
+	mu          sync.Mutex
+}
+
+//开始处理一个请求，域名已注销时返回false，调用方应重新选择后端
+func (p *domainWorker) begin() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.retired {
+		return false
+	}
+	p.pending++
+	return true
+}
+
+//请求处理完成，已注销的域名最后一个请求完成后从注册表中删除
+func (p *domainWorker) end() {
+	p.mu.Lock()
+	p.pending--
+	idle := p.retired && p.pending == 0
+	p.mu.Unlock()
+	if idle {
+		p.registry.forget(p)
+	}
+}
+
+//标记为已注销，返回是否还有进行中的请求
+func (p *domainWorker) retire() (busy bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.retired = true
+	return p.pending > 0
+}
+
+//重新注册时恢复接受请求
+func (p *domainWorker) revive() {
+	p.mu.Lock()
+	p.retired = false
+	p.mu.Unlock()
+}
+
+//向用户返回HTTP请求结果
+func (p *domainWorker) httpResponse(args map[string][]byte) {
+
+	requestIdByte, ok := args["requestId"]
+	if !ok {
+		fmt.Println("domainWorker-httpResponse:args索引requestId无法找到.")
+		return
+	}
+	requestId := binary.LittleEndian.Uint32(requestIdByte)
+	p.mu.Lock()
+	ch, ok := p.respWriters[requestId]
+	p.mu.Unlock()
+	if !ok {
+		fmt.Println("domainWorker-httpResponse:respWriters索引" + string(requestIdByte) + "无法找到.")
+		return
+	}
+	ch <- args
+	return
+}
+
+//来自用户的HTTP请求
+func (p *domainWorker) httpHandleFunc(w http.ResponseWriter, r *http.Request) {
+	if p.tcpW.conn == nil {
+		fmt.Println("domainWorker-httpHandleFunc:p.tcpW.conn")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	//span从用户请求开始，tunnel是等待后端返回响应头的部分，
+	//后端的span是tunnel的子span，二者之差即隧道本身的耗时
+	tracer := p.tcpW.server.tracer
+	span := tracer.Start("HTTP "+r.Method, mvctrace.KindServer, mvctrace.FromHeader(r.Header))
+	defer span.End()
+	span.SetAttribute("http.method", r.Method)
+	span.SetAttribute("http.host", r.Host)
+	span.SetAttribute("http.target", r.RequestURI)
+	if span != nil {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() {
+			span.SetAttribute("http.status_code", strconv.Itoa(sw.status))
+			if sw.status >= 500 {
+				span.SetError(errors.New(http.StatusText(sw.status)))
+			}
+		}()
+		w = sw
+	}
+	tunnel := tracer.Start("tcpWorker.HttpRequest", mvctrace.KindClient, span.SpanContext())
+	defer tunnel.End()
+	//后端未开启追踪时，上游服务也能接上这条追踪
+	mvctrace.InjectHeader(r.Header, tunnel.SpanContext())
+	reqBytes, err := httputil.DumpRequest(r, true)
+	if err != nil {
+		fmt.Println("domainWorker-httpHandleFunc:httputil.DumpRequest = err")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	//响应体通过独立的流返回，受流量控制，用户读取得慢时后端会暂停发送
+	//旧版本与未告知支持流的客户端收到stm|帧会断开，响应体随resp一起返回
+	var stream *tcpmvc.Stream
+	if p.tcpW.tmvc.PeerSupports(tcpmvc.CapStream) {
+		stream, err = p.tcpW.tmvc.OpenStream()
+		if err != nil {
+			fmt.Println("domainWorker-httpHandleFunc:p.tcpW.tmvc.OpenStream = " + err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	//向应用服务器发送HTTP处理请求
+	data := backendClient{p.tcpW.tmvc}.NewHttpRequest(nil)
+	data.Args["domain"] = []byte(r.Host)
+	data.Args["request"] = reqBytes
+	requestId := p.getRequestId()
+	data.Args["requestId"] = make([]byte, 4)
+	binary.LittleEndian.PutUint32(data.Args["requestId"], requestId)
+	mvctrace.Inject(data, tunnel.SpanContext())
+	//截止时间随Data发送，后端收到时已超时则不再请求
+	var timeout <-chan time.Time
+	if d := p.tcpW.server.Config().requestTimeout(p.domain); d > 0 {
+		data.SetTimeout(d)
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	var body io.Reader
+	if stream != nil {
+		defer stream.Close()
+		body = stream
+		data.Args["stream"] = make([]byte, 4)
+		binary.LittleEndian.PutUint32(data.Args["stream"], stream.ID())
+	}
+	//先登记再发送，避免回复先于登记到达
+	ch := make(chan map[string][]byte, 1)
+	p.mu.Lock()
+	p.respWriters[requestId] = ch
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.respWriters, requestId)
+		p.mu.Unlock()
+	}()
+	err = p.tcpW.tmvc.Write(data)
+	if err != nil {
+		fmt.Println("domainWorker-httpHandleFunc:p.tcpW.tmvc.Write = " + err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	select {
+	case args := <-ch:
+		tunnel.End()
+		status, ok := args["status"]
+		if !ok {
+			fmt.Println("domainWorker-httpResponse:args索引status无法找到.")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if string(status) != "200" {
+			fmt.Println("status != 200")
+			http.Error(w, string(args["msg"]), http.StatusNotFound)
+			return
+		}
+		responseByte, ok := args["resp"]
+		if !ok {
+			fmt.Println("domainWorker-httpResponse:args索引resp无法找到.")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		//使用流时resp只包含响应头，响应体从流中读取
+		byteReader := bytes.NewReader(responseByte)
+		bufioReader := bufio.NewReader(byteReader)
+		response, err := http.ReadResponse(bufioReader, r)
+		if err != nil {
+			fmt.Println("domainWorker-httpResponse:http.ReadResponse = " + err.Error())
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for k, v := range response.Header {
+			for _, v2 := range v {
+				w.Header().Add(k, v2)
+			}
+		}
+		w.WriteHeader(response.StatusCode)
+		if body == nil {
+			body = response.Body
+		}
+		_, err = io.Copy(w, body)
+		if err != nil {
+			fmt.Println("写入数据不完整：" + err.Error())
+		}
+	case <-timeout:
+		tunnel.SetError(tcpmvc.ErrDeadlineExceeded)
+		fmt.Println("domainWorker-httpHandleFunc:等待后端响应超时")
+		http.Error(w, http.StatusText(http.StatusGatewayTimeout), http.StatusGatewayTimeout)
+	case <-r.Context().Done():
+		fmt.Println("domainWorker-httpHandleFunc:用户已断开")
+	}
+	return
+}
+
+//记录返回给用户的状态码
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (p *domainWorker) getRequestId() uint32 {
+	p.mu.Lock()
+	p.requestId++
+	p.mu.Unlock()
+	return p.requestId
+}
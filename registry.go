@@ -0,0 +1,305 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+)
+
+//注册表变化的类型
+type RegistryEventType int
+
+const (
+	WorkerConnected    RegistryEventType = iota //后端连接
+	WorkerDisconnected                          //后端断开，其注册的域名已先逐个注销
+	DomainRegistered                            //后端注册了域名
+	DomainUnregistered                          //后端注销了域名，或因断开被自动注销
+)
+
+var registryEventNames = map[RegistryEventType]string{
+	WorkerConnected:    "后端连接",
+	WorkerDisconnected: "后端断开",
+	DomainRegistered:   "注册域名",
+	DomainUnregistered: "注销域名",
+}
+
+func (t RegistryEventType) String() string {
+	return registryEventNames[t]
+}
+
+//注册表的一次变化
+type RegistryEvent struct {
+	Type   RegistryEventType
+	Worker *tcpWorker
+	Domain string //域名事件的域名
+}
+
+func (e RegistryEvent) String() string {
+	s := e.Type.String() + "：" + e.Worker.conn.RemoteAddr().String()
+	if e.Domain != "" {
+		s += " " + e.Domain
+	}
+	return s
+}
+
+var (
+	ErrWorkerUnknown     = errors.New("tcpWorker未连接或已断开")
+	ErrDomainRegistered  = errors.New("已注册过该域名")
+	ErrDomainNotFound    = errors.New("未注册该域名")
+	ErrDomainMaxBackends = errors.New("该域名的后端已达上限")
+)
+
+//已连接的后端与其代理的域名，可在多个goroutine中同时使用
+//订阅者按发生顺序收到变化，回调在修改注册表的goroutine中调用，不能阻塞，也不能再修改注册表
+type Registry struct {
+	mu       sync.RWMutex
+	workers  map[*tcpWorker]map[string]*domainWorker //后端及其注册的域名
+	order    []*tcpWorker                            //按连接先后排列的后端
+	domains  map[string][]*domainWorker              //域名及代理该域名的后端
+	retired  map[*tcpWorker]map[string]*domainWorker //已注销但仍有进行中请求的域名，最后一个请求完成后删除
+	emitMu   sync.Mutex                              //保证回调按变化的顺序调用
+	subs     map[int]func(RegistryEvent)
+	nextSubs int
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		workers: make(map[*tcpWorker]map[string]*domainWorker),
+		domains: make(map[string][]*domainWorker),
+		retired: make(map[*tcpWorker]map[string]*domainWorker),
+		subs:    make(map[int]func(RegistryEvent)),
+	}
+}
+
+//订阅注册表的变化，返回取消订阅的函数
+func (r *Registry) Subscribe(fn func(RegistryEvent)) (cancel func()) {
+	r.emitMu.Lock()
+	id := r.nextSubs
+	r.nextSubs++
+	r.subs[id] = fn
+	r.emitMu.Unlock()
+	return func() {
+		r.emitMu.Lock()
+		delete(r.subs, id)
+		r.emitMu.Unlock()
+	}
+}
+
+//在持有mu时调用，emitMu在释放mu前获取，保证回调的顺序与变化的顺序一致
+func (r *Registry) emitLocked(events ...RegistryEvent) func() {
+	r.emitMu.Lock()
+	return func() {
+		defer r.emitMu.Unlock()
+		for _, e := range events {
+			for _, fn := range r.subs {
+				fn(e)
+			}
+		}
+	}
+}
+
+//登记新连接的后端
+func (r *Registry) AddWorker(w *tcpWorker) {
+	r.mu.Lock()
+	if _, ok := r.workers[w]; ok {
+		r.mu.Unlock()
+		return
+	}
+	r.workers[w] = make(map[string]*domainWorker)
+	r.order = append(r.order, w)
+	emit := r.emitLocked(RegistryEvent{Type: WorkerConnected, Worker: w})
+	r.mu.Unlock()
+	emit()
+}
+
+//删除断开的后端，并注销其注册的所有域名，返回被注销的域名
+func (r *Registry) RemoveWorker(w *tcpWorker) ([]string, error) {
+	r.mu.Lock()
+	own, ok := r.workers[w]
+	if !ok {
+		r.mu.Unlock()
+		return nil, ErrWorkerUnknown
+	}
+	var events []RegistryEvent
+	var removed []string
+	for domain := range own {
+		r.removeDomainLocked(w, domain)
+		removed = append(removed, domain)
+		events = append(events, RegistryEvent{Type: DomainUnregistered, Worker: w, Domain: domain})
+	}
+	delete(r.workers, w)
+	delete(r.retired, w)
+	for i, v := range r.order {
+		if v == w {
+			r.order = append(r.order[:i:i], r.order[i+1:]...)
+			break
+		}
+	}
+	events = append(events, RegistryEvent{Type: WorkerDisconnected, Worker: w})
+	emit := r.emitLocked(events...)
+	r.mu.Unlock()
+	emit()
+	return removed, nil
+}
+
+//后端注册域名，maxBackends大于0时限制代理该域名的后端数量
+func (r *Registry) Register(w *tcpWorker, domain string, maxBackends int) (*domainWorker, error) {
+	r.mu.Lock()
+	own, ok := r.workers[w]
+	if !ok {
+		r.mu.Unlock()
+		return nil, ErrWorkerUnknown
+	}
+	if _, ok := own[domain]; ok {
+		r.mu.Unlock()
+		return nil, ErrDomainRegistered
+	}
+	if maxBackends > 0 && len(r.domains[domain]) >= maxBackends {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("%w%d", ErrDomainMaxBackends, maxBackends)
+	}
+	//重新注册时沿用原domainWorker，注销前发出的请求的回复仍能送达
+	dWorker, ok := r.retired[w][domain]
+	if ok {
+		r.deleteRetiredLocked(w, domain)
+		dWorker.revive()
+	} else {
+		dWorker = &domainWorker{domain: domain, tcpW: w, registry: r, respWriters: make(map[uint32]chan map[string][]byte)}
+	}
+	own[domain] = dWorker
+	r.domains[domain] = append(r.domains[domain], dWorker)
+	emit := r.emitLocked(RegistryEvent{Type: DomainRegistered, Worker: w, Domain: domain})
+	r.mu.Unlock()
+	emit()
+	return dWorker, nil
+}
+
+//后端注销域名，进行中的请求仍由原domainWorker完成
+func (r *Registry) Unregister(w *tcpWorker, domain string) error {
+	r.mu.Lock()
+	own, ok := r.workers[w]
+	if !ok {
+		r.mu.Unlock()
+		return ErrWorkerUnknown
+	}
+	if _, ok := own[domain]; !ok {
+		r.mu.Unlock()
+		return ErrDomainNotFound
+	}
+	r.removeDomainLocked(w, domain)
+	emit := r.emitLocked(RegistryEvent{Type: DomainUnregistered, Worker: w, Domain: domain})
+	r.mu.Unlock()
+	emit()
+	return nil
+}
+
+//从域名列表中删除后端的domainWorker，调用方持有mu
+//仍有进行中的请求时移入retired，使其回复仍能送达
+func (r *Registry) removeDomainLocked(w *tcpWorker, domain string) {
+	if d := r.workers[w][domain]; d.retire() {
+		if r.retired[w] == nil {
+			r.retired[w] = make(map[string]*domainWorker)
+		}
+		r.retired[w][domain] = d
+	}
+	delete(r.workers[w], domain)
+	list := r.domains[domain]
+	for i, d := range list {
+		if d.tcpW == w {
+			//复制而不是原地修改，Lookup返回的切片可能仍在使用
+			list = append(list[:i:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) == 0 {
+		delete(r.domains, domain)
+	} else {
+		r.domains[domain] = list
+	}
+}
+
+func (r *Registry) deleteRetiredLocked(w *tcpWorker, domain string) {
+	delete(r.retired[w], domain)
+	if len(r.retired[w]) == 0 {
+		delete(r.retired, w)
+	}
+}
+
+//已注销的domainWorker的请求全部完成，从retired中删除；期间已重新注册或后端已断开时不做处理
+func (r *Registry) forget(d *domainWorker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.retired[d.tcpW][d.domain] == d {
+		r.deleteRetiredLocked(d.tcpW, d.domain)
+	}
+}
+
+//代理域名的所有后端，返回的切片不会被修改
+func (r *Registry) Lookup(domain string) []*domainWorker {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.domains[domain]
+}
+
+//随机选择一个代理域名的后端并开始一个请求，没有时返回nil，请求完成后需调用end
+//选中的后端恰好注销时重新选择
+func (r *Registry) Begin(domain string) *domainWorker {
+	for {
+		d := r.Pick(domain)
+		if d == nil || d.begin() {
+			return d
+		}
+	}
+}
+
+//随机选择一个代理域名的后端，没有时返回nil
+func (r *Registry) Pick(domain string) *domainWorker {
+	list := r.Lookup(domain)
+	if len(list) == 0 {
+		return nil
+	}
+	return list[rand.Intn(len(list))]
+}
+
+//后端注册的domainWorker，用于送达回复，已注销的域名同样返回
+func (r *Registry) DomainWorker(w *tcpWorker, domain string) (*domainWorker, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if d, ok := r.workers[w][domain]; ok {
+		return d, true
+	}
+	d, ok := r.retired[w][domain]
+	return d, ok
+}
+
+//后端注册的所有域名，按字典序排列
+func (r *Registry) Domains(w *tcpWorker) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	domains := make([]string, 0, len(r.workers[w]))
+	for domain := range r.workers[w] {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+//按连接先后排列的所有后端
+func (r *Registry) Workers() []*tcpWorker {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]*tcpWorker(nil), r.order...)
+}
+
+//所有域名及代理它们的后端
+func (r *Registry) Snapshot() map[string][]*domainWorker {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	snap := make(map[string][]*domainWorker, len(r.domains))
+	for domain, list := range r.domains {
+		snap[domain] = list
+	}
+	return snap
+}
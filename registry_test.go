@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+)
+
+func TestRegistryRegister(t *testing.T) {
+	r := NewRegistry()
+	a, b := &tcpWorker{}, &tcpWorker{}
+	if _, err := r.Register(a, "a.test", 0); !errors.Is(err, ErrWorkerUnknown) {
+		t.Fatalf("register before AddWorker: %v", err)
+	}
+	r.AddWorker(a)
+	r.AddWorker(b)
+	da, err := r.Register(a, "a.test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Register(a, "a.test", 0); !errors.Is(err, ErrDomainRegistered) {
+		t.Fatalf("register twice: %v", err)
+	}
+	db, _ := r.Register(b, "a.test", 0)
+	r.Register(a, "0.test", 0)
+	if got := r.Lookup("a.test"); !reflect.DeepEqual(got, []*domainWorker{da, db}) {
+		t.Fatalf("lookup %v", got)
+	}
+	if got := r.Domains(a); !reflect.DeepEqual(got, []string{"0.test", "a.test"}) {
+		t.Fatalf("domains %v", got)
+	}
+	for i := 0; i < 10; i++ {
+		if d := r.Pick("a.test"); d != da && d != db {
+			t.Fatalf("pick %v", d)
+		}
+	}
+
+	if err := r.Unregister(a, "a.test"); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Unregister(a, "a.test"); !errors.Is(err, ErrDomainNotFound) {
+		t.Fatalf("unregister twice: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if d := r.Pick("a.test"); d != db {
+			t.Fatalf("pick after unregister %v", d)
+		}
+	}
+	r.Unregister(b, "a.test")
+	if r.Pick("a.test") != nil || r.Lookup("a.test") != nil {
+		t.Fatal("domain without backends should not be found")
+	}
+	if _, ok := r.Snapshot()["a.test"]; ok {
+		t.Fatal("snapshot still has the domain")
+	}
+}
+
+func TestRegistryRemoveWorker(t *testing.T) {
+	r := NewRegistry()
+	a, b := &tcpWorker{}, &tcpWorker{}
+	r.AddWorker(a)
+	r.AddWorker(b)
+	r.Register(a, "x.test", 0)
+	r.Register(a, "y.test", 0)
+	db, _ := r.Register(b, "y.test", 0)
+	removed, err := r.RemoveWorker(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(removed)
+	if !reflect.DeepEqual(removed, []string{"x.test", "y.test"}) {
+		t.Fatalf("removed %v", removed)
+	}
+	if r.Lookup("x.test") != nil || !reflect.DeepEqual(r.Lookup("y.test"), []*domainWorker{db}) {
+		t.Fatalf("lookup after remove %v %v", r.Lookup("x.test"), r.Lookup("y.test"))
+	}
+	if got := r.Workers(); !reflect.DeepEqual(got, []*tcpWorker{b}) {
+		t.Fatalf("workers %v", got)
+	}
+	if _, err := r.RemoveWorker(a); !errors.Is(err, ErrWorkerUnknown) {
+		t.Fatalf("remove twice: %v", err)
+	}
+	if _, ok := r.DomainWorker(a, "x.test"); ok {
+		t.Fatal("removed worker still routes replies")
+	}
+}
+
+func TestRegistryMaxBackends(t *testing.T) {
+	r := NewRegistry()
+	a, b := &tcpWorker{}, &tcpWorker{}
+	r.AddWorker(a)
+	r.AddWorker(b)
+	if _, err := r.Register(a, "m.test", 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Register(b, "m.test", 1); !errors.Is(err, ErrDomainMaxBackends) {
+		t.Fatalf("register over limit: %v", err)
+	}
+	if _, err := r.Register(b, "m.test", 2); err != nil {
+		t.Fatalf("register under a larger limit: %v", err)
+	}
+	//注销后空出的位置可以再注册
+	r.Unregister(a, "m.test")
+	r.Unregister(b, "m.test")
+	if _, err := r.Register(b, "m.test", 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistryEvents(t *testing.T) {
+	r := NewRegistry()
+	w := &tcpWorker{}
+	var got []string
+	cancel := r.Subscribe(func(e RegistryEvent) {
+		if e.Worker != w {
+			t.Errorf("event for another worker: %+v", e)
+		}
+		got = append(got, e.Type.String()+" "+e.Domain)
+	})
+	var other []RegistryEventType
+	r.Subscribe(func(e RegistryEvent) {
+		other = append(other, e.Type)
+	})
+	r.AddWorker(w)
+	r.AddWorker(w)
+	r.Register(w, "e.test", 0)
+	r.Register(w, "e.test", 0)
+	r.Unregister(w, "e.test")
+	r.Register(w, "f.test", 0)
+	r.RemoveWorker(w)
+	want := []string{"后端连接 ", "注册域名 e.test", "注销域名 e.test", "注册域名 f.test", "注销域名 f.test", "后端断开 "}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("events %q", got)
+	}
+
+	cancel()
+	r.AddWorker(w)
+	if len(got) != len(want) {
+		t.Fatalf("event after cancel: %q", got[len(want):])
+	}
+	if len(other) != len(want)+1 || other[len(other)-1] != WorkerConnected {
+		t.Fatalf("other subscriber %v", other)
+	}
+}
+
+//注销时仍有进行中的请求，回复仍能送达，请求完成后不再保留
+func TestRegistryRetired(t *testing.T) {
+	r := NewRegistry()
+	w := &tcpWorker{}
+	r.AddWorker(w)
+	d, _ := r.Register(w, "r.test", 0)
+	if r.Begin("r.test") != d {
+		t.Fatal("begin should pick the only backend")
+	}
+	r.Unregister(w, "r.test")
+	if got, ok := r.DomainWorker(w, "r.test"); !ok || got != d {
+		t.Fatal("reply of an in-flight request is not routed")
+	}
+	if d.begin() || r.Begin("r.test") != nil {
+		t.Fatal("unregistered domain accepted a new request")
+	}
+	d.end()
+	if _, ok := r.DomainWorker(w, "r.test"); ok || len(r.retired) != 0 {
+		t.Fatalf("retired domain kept after its requests finished: %v", r.retired)
+	}
+
+	//没有进行中的请求时直接删除
+	r.Register(w, "r.test", 0)
+	r.Unregister(w, "r.test")
+	if len(r.retired) != 0 {
+		t.Fatalf("idle domain retired: %v", r.retired)
+	}
+
+	//请求完成前重新注册，沿用同一个domainWorker
+	d, _ = r.Register(w, "r.test", 0)
+	d.begin()
+	r.Unregister(w, "r.test")
+	again, _ := r.Register(w, "r.test", 0)
+	if again != d || !d.begin() {
+		t.Fatal("re-register should revive the retired domainWorker")
+	}
+	d.end()
+	d.end()
+	if _, ok := r.DomainWorker(w, "r.test"); !ok {
+		t.Fatal("registered domain lost after requests finished")
+	}
+}
+
+//在-race下运行，同时注册、注销、选择后端与断开
+func TestRegistryConcurrent(t *testing.T) {
+	r := NewRegistry()
+	var mu sync.Mutex
+	events := 0
+	r.Subscribe(func(e RegistryEvent) {
+		mu.Lock()
+		events++
+		mu.Unlock()
+	})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			w := &tcpWorker{}
+			r.AddWorker(w)
+			for j := 0; j < 200; j++ {
+				domain := fmt.Sprintf("%d.test", j%4)
+				r.Register(w, domain, 6)
+				if d := r.Begin(domain); d != nil {
+					r.DomainWorker(d.tcpW, domain)
+					d.end()
+				}
+				r.Snapshot()
+				r.Domains(w)
+				if j%3 == 0 {
+					r.Unregister(w, domain)
+				}
+			}
+			r.RemoveWorker(w)
+		}(i)
+	}
+	wg.Wait()
+	if len(r.Workers()) != 0 || len(r.Snapshot()) != 0 || len(r.retired) != 0 {
+		t.Fatalf("registry not empty: %v %v %v", r.Workers(), r.Snapshot(), r.retired)
+	}
+	if events == 0 {
+		t.Fatal("no events")
+	}
+}
@@ -1,221 +1,537 @@
-package main
-
-import (
-	"errors"
-	"fmt"
-	"log"
-	"math/rand"
-	"net"
-	"net/http"
-	"os"
-	"pointTest/tcpProxy/tcpmvc"
-	"strconv"
-	"time"
-)
-
-func main() {
-	pServer := &ProxyServer{tcpPort: 7000, httpPort: 7100}
-	pServer.Start()
-}
-
-//代理服务器，提供代理管理、将HTTP分配到具体proxyWorker
-type ProxyServer struct {
-	tcpPort      int                        //tcp监听端口
-	tcpListen    *net.TCPListener           //tcp监听链接
-	httpPort     int                        //http监听端口
-	tcpWorkers   []*tcpWorker               //连接上的TCP连接
-	domainProxys map[string][]*domainWorker //代理的域名
-	error_log    string                     //错误日志文件路径
-	access_log   string                     //日志文件路径
-	errorLog     *log.Logger                //错误日志
-	accessFiel   *log.Logger                //日志
-}
-
-func (p *ProxyServer) Start() {
-	//日志
-	if p.error_log == "" {
-		p.error_log = "error.log"
-	}
-	errorFile, err := os.OpenFile(p.error_log, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
-	if err != nil {
-		log.Fatalf("打开错误日志文件(%s)失败:%s", p.error_log, err.Error())
-	}
-	p.errorLog = log.New(errorFile, "error:", log.LstdFlags|log.Lshortfile)
-
-	//初始化
-	p.tcpWorkers = make([]*tcpWorker, 0)
-	p.domainProxys = make(map[string][]*domainWorker)
-	//启动TCP监听
-	fmt.Println("TCP 协议转发, 建立TCP转发服务...")
-
-	p.tcpListen, err = net.ListenTCP("tcp", &net.TCPAddr{net.ParseIP("127.0.0.1"), p.tcpPort, ""})
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "建立TCP：%d 监听失败：%s\n", p.tcpPort, err.Error())
-		p.errorLog.Fatalf("建立TCP：%d 监听失败：%s\n", p.tcpPort, err.Error())
-	}
-	defer p.tcpListen.Close()
-	fmt.Println("监听TCP端口" + strconv.Itoa(p.tcpPort) + "成功，等待客户端连接...")
-
-	//启动HTTP监听
-	go p.httpServer()
-	go p.cmd()
-	for {
-		conn, err := p.tcpListen.AcceptTCP()
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "连接错误：%s", err.Error())
-			continue
-		}
-		fmt.Println("已连接：" + conn.RemoteAddr().String() + " " + time.Now().Format("15:04:05\n"))
-		go p.handleCoon(conn)
-	}
-}
-
-func (p *ProxyServer) cmd() {
-	for {
-		var msg string
-		_, err := fmt.Scanln(&msg)
-		if err != nil {
-			fmt.Println("接收输入错误：" + err.Error())
-			continue
-		}
-		switch msg {
-		case "stop":
-			p.stop()
-		case "status":
-			p.status()
-		default:
-			fmt.Println("错误命令:" + msg)
-		}
-	}
-}
-
-func (p *ProxyServer) stop() {
-	fmt.Println("stop")
-}
-
-func (p *ProxyServer) status() {
-	var tcpSatus string
-	if p.tcpListen != nil {
-		tcpSatus = "运行中"
-	} else {
-		tcpSatus = "未运行"
-	}
-	fmt.Printf("监听TCP端口:%d,%s\n", p.tcpPort, tcpSatus)
-	fmt.Println("\n连接上的TCP：")
-	for x, v := range p.tcpWorkers {
-		fmt.Printf("%d:%s\n", x, v.conn.RemoteAddr().String())
-	}
-	fmt.Println("\n代理的domain：")
-	for x, sliDomain := range p.domainProxys {
-		fmt.Printf("%s,主机数量：%d\n", x, len(sliDomain))
-		for _, d := range sliDomain {
-			fmt.Printf("    %s\n", d.tcpW.conn.RemoteAddr().String())
-		}
-	}
-}
-
-func (p *ProxyServer) handleCoon(c *net.TCPConn) {
-	tcpW := NewTcpWorker()
-	tcpW.conn = c
-	tcpW.server = p
-	defer func() {
-		pan := recover()
-		if pan != nil {
-			fmt.Printf("recover:%v\n", pan)
-			p.errorLog.Printf("%s连接意外断开\n", c.RemoteAddr().String(), pan)
-			panic(pan) //这句已没必要，已经到了goroutine的末尾
-		} else {
-			fmt.Printf("%s客户短失去连接\n", c.RemoteAddr().String())
-		}
-		sTcp := c.RemoteAddr().String()
-		err := p.deTcpWorker(tcpW)
-		if err != nil {
-			p.errorLog.Printf("关闭tcpWorker %s 失败：%s\n", sTcp, err.Error())
-		} else {
-			fmt.Printf("关闭tcpWorker %s 成功\n", sTcp)
-		}
-	}()
-	mvc := tcpmvc.New(c)
-	mvc.Include(tcpW)
-	tcpW.tmvc = mvc
-	p.tcpWorkers = append(p.tcpWorkers, tcpW)
-	tcpW.Welcome()
-	//监听并分发消息
-	mvc.StartHandle()
-}
-
-func (p *ProxyServer) httpServer() {
-	fmt.Fprintf(os.Stderr, "启动HTTP监听，端口：%d\n", p.httpPort)
-	http.HandleFunc("/", p.httpHandleFunc)
-	err := http.ListenAndServe("127.0.0.1:"+strconv.Itoa(p.httpPort), nil) //设置监听的端口
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "监听HTTP:%d端口失败%s\n", p.httpPort, err.Error())
-		os.Exit(1)
-	} else {
-		fmt.Fprintf(os.Stderr, "监听HTTP:%d端口成功.\n", p.httpPort)
-	}
-}
-
-//分发用户HTTP请求
-func (p *ProxyServer) httpHandleFunc(w http.ResponseWriter, r *http.Request) {
-	fmt.Printf("HTTP请求：%s\n", r.Host+r.URL.Path)
-	//将HTTP连接发送到TCP中去
-	tcps, ok := p.domainProxys[r.Host]
-	if !ok || len(tcps) == 0 {
-		fmt.Println("没有tcp后台")
-		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
-		return
-	}
-	//随机一个代理处理
-	ra := rand.New(rand.NewSource(time.Now().UnixNano()))
-	count := ra.Intn(len(tcps))
-	tcps[count].httpHandleFunc(w, r)
-	return
-}
-
-//注册代理域名
-func (p *ProxyServer) registerDomain(domain string, doWorker *domainWorker) error {
-	old, ok := p.domainProxys[domain]
-	if !ok {
-		sliceDomainProxys := make([]*domainWorker, 0)
-		sliceDomainProxys = append(sliceDomainProxys, doWorker)
-		p.domainProxys[domain] = sliceDomainProxys
-	} else {
-		for _, v := range old {
-			if v.tcpW == doWorker.tcpW {
-				return errors.New("该net.TCPConn已存在相同域名的代理")
-			}
-		}
-		p.domainProxys[domain] = append(old, doWorker)
-	}
-	return nil
-}
-
-//删除tcpWorker实例
-func (p *ProxyServer) deTcpWorker(tcp *tcpWorker) error {
-	l := len(p.tcpWorkers)
-	if l == 0 {
-		return errors.New("tcpWorkers已为空，不能再删除")
-	}
-	var isde bool = false
-	for k, v := range p.tcpWorkers {
-		if v == tcp {
-			if k == l-1 {
-				p.tcpWorkers = p.tcpWorkers[:k]
-			} else {
-				p.tcpWorkers = append(p.tcpWorkers[:k], p.tcpWorkers[k+1:]...)
-			}
-			isde = true
-			break
-		}
-	}
-	if !isde {
-		return errors.New("已为空，不能再删除")
-	}
-	err := tcp.conn.Close()
-	if err != nil {
-		_, errOut := fmt.Scanf("关闭TCPConn %s 失败：%s\n", tcp.conn.RemoteAddr().String(), err.Error())
-		return errOut
-	}
-	return nil
-}
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"pointTest/tcpProxy/tcpmvc"
+	"pointTest/tcpProxy/tcpmvc/mvctrace"
+	"pointTest/tcpProxy/tcpmvc/mvcws"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+func main() {
+	configPath := flag.String("config", "", "JSON配置文件路径，命令行中显式给出的参数覆盖文件中的值")
+	DefaultConfig().bindFlags(flag.CommandLine)
+	flag.Parse()
+	//重新加载配置时同样以这些参数覆盖文件中的值
+	overrides := make(map[string]string)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "config" {
+			overrides[f.Name] = f.Value.String()
+		}
+	})
+	config, err := loadConfig(*configPath, overrides)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
+	}
+	pServer := &ProxyServer{config: config, configPath: *configPath, overrides: overrides}
+	pServer.Start()
+}
+
+//代理服务器，提供代理管理、将HTTP分配到具体proxyWorker
+type ProxyServer struct {
+	cmu        sync.RWMutex      //保护config
+	config     *Config           //当前配置，重新加载时整体替换，不修改已发布的Config
+	configPath string            //配置文件路径
+	overrides  map[string]string //命令行中显式给出的参数
+	tcpListen  *net.TCPListener  //tcp监听链接
+	registry   *Registry         //连接上的后端与代理的域名
+	errorLog   *log.Logger       //错误日志
+	accessFiel *log.Logger       //访问日志，为nil时不记录
+	capture    *tcpmvc.Capture   //抓包，为nil时不记录
+	tracer     *mvctrace.Tracer  //为nil时不追踪
+
+	unixListen *net.UnixListener //为nil时未监听Unix socket
+	httpSrv    *http.Server
+	gatewaySrv *http.Server   //为nil时未启动调试网关
+	wmu        sync.Mutex     //保证开始关闭后不再登记新的后端与请求
+	workers    sync.WaitGroup //进行中的handleCoon
+	requests   sync.WaitGroup //进行中的HTTP请求，不含已接管的WebSocket隧道
+	drained    bool           //HTTP服务已关闭，不再登记新的请求
+	stopOnce   sync.Once
+	stopAt     time.Time     //关闭的截止时间，在stopping关闭前设置
+	stopping   chan struct{} //开始关闭时关闭
+	stopped    chan struct{} //关闭完成后关闭
+}
+
+//当前配置，返回的Config不会再被修改
+func (p *ProxyServer) Config() *Config {
+	p.cmu.RLock()
+	defer p.cmu.RUnlock()
+	return p.config
+}
+
+//重新读取配置文件，新配置有错误时保留原配置；已连接的后端不受影响
+//需要重启才能生效的配置项保留原值
+func (p *ProxyServer) reload() error {
+	config, err := loadConfig(p.configPath, p.overrides)
+	if err != nil {
+		return err
+	}
+	p.cmu.Lock()
+	kept := config.keepStatic(p.config)
+	p.config = config
+	p.cmu.Unlock()
+	if len(kept) > 0 {
+		fmt.Printf("以下配置项需要重启才能生效：%s\n", strings.Join(kept, ","))
+	}
+	return nil
+}
+
+//收到SIGHUP时重新加载配置，收到SIGINT或SIGTERM时关闭
+func (p *ProxyServer) signals() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	for sig := range ch {
+		if sig == syscall.SIGHUP {
+			p.reloadAndReport()
+			continue
+		}
+		fmt.Printf("收到%s\n", sig.String())
+		//再次收到时不再等待
+		signal.Reset(syscall.SIGINT, syscall.SIGTERM)
+		go p.stop()
+	}
+}
+
+func (p *ProxyServer) reloadAndReport() {
+	if err := p.reload(); err != nil {
+		fmt.Fprintf(os.Stderr, "重新加载配置失败，继续使用原配置：%s\n", err.Error())
+		p.errorLog.Printf("重新加载配置失败：%s\n", err.Error())
+		return
+	}
+	fmt.Println("重新加载配置成功")
+}
+
+//按当前配置的级别输出到控制台
+func (p *ProxyServer) debugf(format string, a ...interface{}) {
+	if p.Config().logs(levelDebug) {
+		fmt.Printf(format, a...)
+	}
+}
+
+func (p *ProxyServer) infof(format string, a ...interface{}) {
+	if p.Config().logs(levelInfo) {
+		fmt.Printf(format, a...)
+	}
+}
+
+func (p *ProxyServer) Start() {
+	cfg := p.Config()
+	//日志
+	errorFile, err := os.OpenFile(cfg.ErrorLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+	if err != nil {
+		log.Fatalf("打开错误日志文件(%s)失败:%s", cfg.ErrorLog, err.Error())
+	}
+	defer errorFile.Close()
+	p.errorLog = log.New(errorFile, "error:", log.LstdFlags|log.Lshortfile)
+	if cfg.AccessLog != "" {
+		accessFile, err := os.OpenFile(cfg.AccessLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+		if err != nil {
+			log.Fatalf("打开访问日志文件(%s)失败:%s", cfg.AccessLog, err.Error())
+		}
+		defer accessFile.Close()
+		p.accessFiel = log.New(accessFile, "", log.LstdFlags)
+	}
+	if cfg.CaptureLog != "" {
+		captureFile, err := os.OpenFile(cfg.CaptureLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0666)
+		if err != nil {
+			log.Fatalf("打开抓包文件(%s)失败:%s", cfg.CaptureLog, err.Error())
+		}
+		defer captureFile.Close()
+		p.capture = tcpmvc.NewCapture(captureFile)
+	}
+	if cfg.Trace != "" {
+		exporter, err := mvctrace.NewExporter(cfg.Trace)
+		if err != nil {
+			log.Fatalf("追踪导出目标(%s)无效:%s", cfg.Trace, err.Error())
+		}
+		defer exporter.Close()
+		p.tracer = mvctrace.New("tcpProxy", exporter)
+	}
+
+	//初始化
+	p.registry = NewRegistry()
+	p.registry.Subscribe(func(e RegistryEvent) {
+		p.infof("%s\n", e.String())
+	})
+	p.stopping = make(chan struct{})
+	p.stopped = make(chan struct{})
+	//启动TCP监听
+	fmt.Println("TCP 协议转发, 建立TCP转发服务...")
+
+	tcpAddr, err := net.ResolveTCPAddr("tcp", cfg.TCPAddr)
+	if err == nil {
+		p.tcpListen, err = net.ListenTCP("tcp", tcpAddr)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "建立TCP：%s 监听失败：%s\n", cfg.TCPAddr, err.Error())
+		p.errorLog.Fatalf("建立TCP：%s 监听失败：%s\n", cfg.TCPAddr, err.Error())
+	}
+	defer p.tcpListen.Close()
+	fmt.Println("监听TCP " + cfg.TCPAddr + "成功，等待客户端连接...")
+
+	if cfg.UnixPath != "" {
+		p.unixListen, err = tcpmvc.ListenUnix(cfg.UnixPath, os.FileMode(cfg.UnixPerm))
+		if err != nil {
+			p.errorLog.Fatalf("建立Unix socket：%s 监听失败：%s\n", cfg.UnixPath, err.Error())
+		}
+		defer p.unixListen.Close()
+		fmt.Println("监听Unix socket " + cfg.UnixPath + "成功")
+		go p.unixServer(p.unixListen)
+	}
+
+	//启动HTTP监听
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", p.httpHandleFunc)
+	p.httpSrv = &http.Server{Addr: cfg.HTTPAddr, Handler: mux}
+	go p.httpServer()
+	if cfg.GatewayAddr != "" {
+		p.gatewaySrv = &http.Server{Addr: cfg.GatewayAddr, Handler: http.HandlerFunc(p.gatewayHandleFunc)}
+		go p.gatewayServer()
+	}
+	go p.cmd()
+	go p.signals()
+	for {
+		conn, err := p.tcpListen.AcceptTCP()
+		if err != nil {
+			select {
+			case <-p.stopping:
+				//等待关闭完成后返回，之后关闭日志与抓包文件、导出剩余的span
+				<-p.stopped
+				return
+			default:
+			}
+			fmt.Fprintf(os.Stderr, "连接错误：%s", err.Error())
+			continue
+		}
+		p.infof("已连接：%s %s\n", conn.RemoteAddr().String(), time.Now().Format("15:04:05"))
+		if !p.beginWorker() {
+			conn.Close()
+			continue
+		}
+		go p.handleCoon(conn)
+	}
+}
+
+//接受本机后端通过Unix socket的连接
+func (p *ProxyServer) unixServer(l *net.UnixListener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			select {
+			case <-p.stopping:
+			default:
+				fmt.Fprintf(os.Stderr, "Unix socket连接错误：%s\n", err.Error())
+			}
+			return
+		}
+		p.infof("已连接(Unix socket)：%s %s\n", l.Addr().String(), time.Now().Format("15:04:05"))
+		if !p.beginWorker() {
+			conn.Close()
+			return
+		}
+		go p.handleCoon(conn)
+	}
+}
+
+func (p *ProxyServer) cmd() {
+	for {
+		var msg string
+		_, err := fmt.Scanln(&msg)
+		if err != nil {
+			fmt.Println("接收输入错误：" + err.Error())
+			continue
+		}
+		switch msg {
+		case "stop":
+			p.stop()
+		case "status":
+			p.status()
+		case "reload":
+			p.reloadAndReport()
+		default:
+			fmt.Println("错误命令:" + msg)
+		}
+	}
+}
+
+//登记一个handleCoon，已开始关闭时返回false，调用方应直接关闭连接
+func (p *ProxyServer) beginWorker() bool {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	select {
+	case <-p.stopping:
+		return false
+	default:
+	}
+	p.workers.Add(1)
+	return true
+}
+
+//登记一个HTTP请求，HTTP服务已关闭时返回false
+func (p *ProxyServer) beginRequest() bool {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	if p.drained {
+		return false
+	}
+	p.requests.Add(1)
+	return true
+}
+
+//通知后端代理即将关闭并断开隧道
+//写入的截止时间取自ctx，不再读取的后端不会使关闭一直阻塞
+func (p *ProxyServer) shutdownWorker(ctx context.Context, tcpW *tcpWorker) {
+	if deadline, ok := ctx.Deadline(); ok {
+		tcpW.conn.SetWriteDeadline(deadline)
+	}
+	err := backendClient{tcpW.tmvc}.Shutdown(map[string][]byte{"msg": []byte("代理服务器关闭")})
+	if err != nil {
+		p.errorLog.Printf("通知后端%s关闭失败：%s\n", tcpW.conn.RemoteAddr().String(), err.Error())
+	}
+	tcpW.conn.Close()
+}
+
+//停止接受新的隧道与HTTP连接，等待进行中的HTTP请求完成，通知后端后断开所有隧道，之后Start返回
+//超过shutdown_timeout时强制关闭HTTP连接，仍等待处理函数返回，Start返回后不再有请求使用日志与追踪
+func (p *ProxyServer) stop() {
+	p.stopOnce.Do(func() {
+		timeout := time.Duration(p.Config().ShutdownTimeout)
+		fmt.Printf("正在关闭，最多等待%s...\n", timeout)
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		p.wmu.Lock()
+		p.stopAt, _ = ctx.Deadline()
+		close(p.stopping)
+		p.wmu.Unlock()
+		p.tcpListen.Close()
+		if p.unixListen != nil {
+			p.unixListen.Close()
+		}
+		if p.gatewaySrv != nil {
+			p.gatewaySrv.Shutdown(ctx)
+		}
+		//WebSocket隧道已被接管，不在Shutdown等待的连接中
+		if err := p.httpSrv.Shutdown(ctx); err != nil {
+			fmt.Fprintf(os.Stderr, "等待HTTP请求完成超时：%s\n", err.Error())
+			p.httpSrv.Close()
+		}
+		p.wmu.Lock()
+		p.drained = true
+		p.wmu.Unlock()
+		//同时通知所有后端，超时后不再等待通知写完，直接断开
+		workers := p.registry.Workers()
+		notified := make(chan struct{})
+		go func() {
+			var wg sync.WaitGroup
+			for _, tcpW := range workers {
+				wg.Add(1)
+				go func(tcpW *tcpWorker) {
+					defer wg.Done()
+					p.shutdownWorker(ctx, tcpW)
+				}(tcpW)
+			}
+			wg.Wait()
+			close(notified)
+		}()
+		select {
+		case <-notified:
+		case <-ctx.Done():
+			fmt.Fprintln(os.Stderr, "通知后端关闭超时")
+		}
+		for _, tcpW := range workers {
+			tcpW.conn.Close()
+		}
+		//连接都已关闭，handleCoon与请求的处理函数会很快返回
+		p.workers.Wait()
+		p.requests.Wait()
+		fmt.Println("已关闭")
+		close(p.stopped)
+	})
+}
+
+func (p *ProxyServer) status() {
+	var tcpSatus string
+	if p.tcpListen != nil {
+		tcpSatus = "运行中"
+	} else {
+		tcpSatus = "未运行"
+	}
+	fmt.Printf("监听TCP:%s,%s\n", p.Config().TCPAddr, tcpSatus)
+	fmt.Println("\n连接上的TCP：")
+	for x, v := range p.registry.Workers() {
+		fmt.Printf("%d:%s\n", x, v.conn.RemoteAddr().String())
+	}
+	fmt.Println("\n代理的domain：")
+	for x, sliDomain := range p.registry.Snapshot() {
+		fmt.Printf("%s,主机数量：%d\n", x, len(sliDomain))
+		for _, d := range sliDomain {
+			fmt.Printf("    %s\n", d.tcpW.conn.RemoteAddr().String())
+		}
+	}
+}
+
+//c为TCP连接或WebSocket隧道，调用前需已由beginWorker登记
+func (p *ProxyServer) handleCoon(c net.Conn) {
+	defer p.workers.Done()
+	tcpW := NewTcpWorker()
+	tcpW.conn = c
+	tcpW.server = p
+	defer func() {
+		pan := recover()
+		if pan != nil {
+			fmt.Printf("recover:%v\n", pan)
+			p.errorLog.Printf("%s连接意外断开:%v\n", c.RemoteAddr().String(), pan)
+			panic(pan) //这句已没必要，已经到了goroutine的末尾
+		} else {
+			p.infof("%s客户短失去连接\n", c.RemoteAddr().String())
+		}
+		sTcp := c.RemoteAddr().String()
+		err := p.deTcpWorker(tcpW)
+		if err != nil {
+			p.errorLog.Printf("关闭tcpWorker %s 失败：%s\n", sTcp, err.Error())
+		} else {
+			p.infof("关闭tcpWorker %s 成功\n", sTcp)
+		}
+	}()
+	mvc := tcpmvc.New(c)
+	if p.capture != nil {
+		mvc.Hook = p.capture.Hook(c.RemoteAddr().String())
+	}
+	mvc.BatchWindow = time.Millisecond //对端告知支持批量帧后，合并短时间内的多个小消息
+	mvc.Include(tcpW)
+	tcpW.tmvc = mvc
+	p.registry.AddWorker(tcpW)
+	//开始关闭后才登记的后端不在stop通知的列表中，由自己通知并断开
+	select {
+	case <-p.stopping:
+		ctx, cancel := context.WithDeadline(context.Background(), p.stopAt)
+		p.shutdownWorker(ctx, tcpW)
+		cancel()
+	default:
+	}
+	//对端可能是旧版本客户端或JSON-RPC客户端，确定后再发送欢迎消息
+	mvc.Ready = tcpW.Welcome
+	//监听并分发消息
+	mvc.StartHandle()
+}
+
+func (p *ProxyServer) httpServer() {
+	cfg := p.Config()
+	fmt.Fprintf(os.Stderr, "启动HTTP监听，地址：%s\n", cfg.HTTPAddr)
+	var err error
+	if cfg.TLS.CertFile != "" {
+		err = p.httpSrv.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	} else {
+		err = p.httpSrv.ListenAndServe()
+	}
+	if err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "监听HTTP:%s失败%s\n", cfg.HTTPAddr, err.Error())
+		os.Exit(1)
+	}
+}
+
+//调试网关
+func (p *ProxyServer) gatewayServer() {
+	fmt.Fprintf(os.Stderr, "启动调试网关，地址：%s\n", p.gatewaySrv.Addr)
+	err := p.gatewaySrv.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "监听调试网关%s失败%s\n", p.gatewaySrv.Addr, err.Error())
+		os.Exit(1)
+	}
+}
+
+//按域名选择后端，其余路径交给tcpmvc.Gateway
+func (p *ProxyServer) gatewayHandleFunc(w http.ResponseWriter, r *http.Request) {
+	if !p.beginRequest() {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	defer p.requests.Done()
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	i := strings.IndexByte(path, '/')
+	if i <= 0 {
+		http.Error(w, "路径应为/{域名}/{model}/{method}", http.StatusNotFound)
+		return
+	}
+	tcps := p.registry.Lookup(path[:i])
+	if len(tcps) == 0 {
+		http.Error(w, "没有tcp后台", http.StatusNotFound)
+		return
+	}
+	gateway := &tcpmvc.Gateway{Mvc: tcps[0].tcpW.tmvc, Remote: true, Timeout: p.Config().requestTimeout(path[:i])}
+	http.StripPrefix("/"+path[:i], gateway).ServeHTTP(w, r)
+}
+
+//分发用户HTTP请求
+func (p *ProxyServer) httpHandleFunc(w http.ResponseWriter, r *http.Request) {
+	if wsPath := p.Config().WSPath; wsPath != "" && r.URL.Path == wsPath && mvcws.IsWebSocket(r) {
+		p.handleTunnel(w, r)
+		return
+	}
+	if !p.beginRequest() {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	defer p.requests.Done()
+	if p.accessFiel != nil {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		start := time.Now()
+		defer func() {
+			p.accessFiel.Printf("%s %s %s%s %d %s\n", r.RemoteAddr, r.Method, r.Host, r.URL.RequestURI(), sw.status, time.Since(start))
+		}()
+		w = sw
+	}
+	p.debugf("HTTP请求：%s\n", r.Host+r.URL.Path)
+	//将HTTP连接发送到TCP中去
+	//随机一个代理处理
+	dWorker := p.registry.Begin(r.Host)
+	if dWorker == nil {
+		p.debugf("没有tcp后台\n")
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+	defer dWorker.end()
+	dWorker.httpHandleFunc(w, r)
+}
+
+//后端通过WebSocket连接，之后与TCP连接相同
+func (p *ProxyServer) handleTunnel(w http.ResponseWriter, r *http.Request) {
+	if !p.beginWorker() {
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	}
+	conn, err := mvcws.Upgrade(w, r)
+	if err != nil {
+		p.workers.Done()
+		fmt.Fprintf(os.Stderr, "WebSocket握手失败：%s\n", err.Error())
+		return
+	}
+	p.infof("已连接(WebSocket)：%s %s\n", conn.RemoteAddr().String(), time.Now().Format("15:04:05"))
+	p.handleCoon(conn)
+}
+
+//删除tcpWorker实例，其注册的域名随之注销，不再分配新的请求
+func (p *ProxyServer) deTcpWorker(tcp *tcpWorker) error {
+	if _, err := p.registry.RemoveWorker(tcp); err != nil {
+		return err
+	}
+	//关闭时已断开的连接不算失败
+	err := tcp.conn.Close()
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		return fmt.Errorf("关闭TCPConn %s 失败：%s", tcp.conn.RemoteAddr().String(), err.Error())
+	}
+	return nil
+}
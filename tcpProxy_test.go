@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"pointTest/tcpProxy/tcpmvc"
+	"testing"
+	"time"
+)
+
+//不监听HTTP端口的ProxyServer，后端通过net.Pipe接入
+func newTestProxy(t *testing.T, config *Config) *ProxyServer {
+	p := &ProxyServer{
+		config:   config,
+		registry: NewRegistry(),
+		errorLog: log.New(ioutil.Discard, "", 0),
+		httpSrv:  &http.Server{},
+		stopping: make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}
+	var err error
+	p.tcpListen, err = net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+//接入一个后端，返回代理端登记的tcpWorker
+func connectBackend(t *testing.T, p *ProxyServer, proxySide net.Conn) *tcpWorker {
+	if !p.beginWorker() {
+		t.Fatal("worker refused")
+	}
+	go p.handleCoon(proxySide)
+	deadline := time.Now().Add(2 * time.Second)
+	for len(p.registry.Workers()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("backend not registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return p.registry.Workers()[0]
+}
+
+//后端不再读取时，通知关闭的写入不能阻塞stop，超过shutdown_timeout后断开连接并返回
+func TestStopStalledBackend(t *testing.T) {
+	config := DefaultConfig()
+	config.ShutdownTimeout = Duration(200 * time.Millisecond)
+	config.LogLevel = "error"
+	p := newTestProxy(t, config)
+
+	//net.Pipe没有缓冲，对端不读取时写入一直阻塞
+	proxySide, backendSide := net.Pipe()
+	defer backendSide.Close()
+	connectBackend(t, p, proxySide)
+
+	start := time.Now()
+	stopped := make(chan struct{})
+	go func() {
+		p.stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stop blocked on a backend that never reads")
+	}
+	if elapsed := time.Since(start); elapsed < time.Duration(config.ShutdownTimeout) {
+		t.Fatalf("stop returned after %s, before the notice could time out", elapsed)
+	}
+	if len(p.registry.Workers()) != 0 {
+		t.Fatalf("workers left after stop: %v", p.registry.Workers())
+	}
+	if p.beginWorker() {
+		t.Fatal("worker accepted after stop")
+	}
+}
+
+//未告知支持流的后端收到的请求不带stream参数，响应体随resp一起返回
+func TestInlineBodyWithoutStreamCap(t *testing.T) {
+	config := DefaultConfig()
+	config.LogLevel = "error"
+	p := newTestProxy(t, config)
+	defer p.tcpListen.Close()
+
+	proxySide, backendSide := net.Pipe()
+	defer backendSide.Close()
+	backend := tcpmvc.New(backendSide)
+	backend.HandleData("tcpWorker", "HttpRequest", func(data *tcpmvc.Data) {
+		if _, ok := data.Args["stream"]; ok {
+			t.Error("stream opened to a peer without stream support")
+		}
+		resp := tcpmvc.NewData()
+		resp.Model = "tcpWorker"
+		resp.Method = "HttpResponse"
+		resp.Args["domain"] = data.Args["domain"]
+		resp.Args["requestId"] = data.Args["requestId"]
+		resp.Args["status"] = []byte("200")
+		resp.Args["resp"] = []byte("HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nhello")
+		backend.Write(resp)
+	})
+	go backend.StartHandle()
+	tcpW := connectBackend(t, p, proxySide)
+	worker, err := p.registry.Register(tcpW, "a.test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		worker.httpHandleFunc(w, httptest.NewRequest("GET", "http://a.test/", nil))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("response body never arrived")
+	}
+	if w.Code != http.StatusOK || w.Body.String() != "hello" {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+}
@@ -1,88 +1,99 @@
-package main
-
-import (
-	"fmt"
-	"net"
-	"pointTest/tcpProxy/tcpmvc"
-)
-
-type tcpWorker struct {
-	conn    *net.TCPConn
-	server  *ProxyServer //所属的ProxyServer
-	tmvc    *tcpmvc.Mvc  //所关联的mvc对象
-	domains map[string]*domainWorker
-}
-
-func NewTcpWorker() *tcpWorker {
-	t := &tcpWorker{}
-	t.domains = make(map[string]*domainWorker)
-	return t
-}
-
-//来自后端的普通消息
-func (p *tcpWorker) Message(args map[string][]byte) {
-	fmt.Printf("来自后端消息：%s\n", args["msg"])
-}
-
-func (p *tcpWorker) Welcome() {
-	data := tcpmvc.NewData()
-	data.Model = "tcpWorker"
-	data.Method = "Message"
-	data.Args["msg"] = []byte("show me domain")
-	err := p.tmvc.Write(data)
-	if err != nil {
-		fmt.Println("向后端写入数据失败" + err.Error())
-	} else {
-		fmt.Println("向后端写入数据成功")
-	}
-}
-
-//来自后端的HTTP回复
-func (p *tcpWorker) HttpResponse(args map[string][]byte) {
-	domain, ok := args["domain"]
-	if !ok {
-		fmt.Println("tcpWorker-HttpResponse:索引domain无法找到")
-		return
-	}
-	worker, ok := p.domains[string(domain)]
-	if !ok {
-		fmt.Printf("tcpWorker-HttpResponse:无该域名（%s）代理\n", domain)
-		return
-	}
-	worker.httpResponse(args)
-}
-
-//domain注册
-func (p *tcpWorker) Register(args map[string][]byte) {
-	outData := tcpmvc.NewData()
-	outData.Model = "tcpWorker"
-	outData.Method = "Message"
-	domain, ok := args["domain"]
-	if !ok {
-		outData.Args["msg"] = []byte("参数缺少domain")
-		p.tmvc.Write(outData)
-		return
-	}
-	//在本tcpWorker注册
-	sDomain := string(domain)
-	_, ok = p.domains[sDomain]
-	if ok {
-		outData.Args["msg"] = []byte("已注册过该域名：" + sDomain)
-		p.tmvc.Write(outData)
-		return
-	}
-	dWorker := &domainWorker{domain: sDomain, tcpW: p, respWriters: make(map[uint32]chan map[string][]byte)}
-	dWorker.domain = sDomain
-	dWorker.tcpW = p
-	p.domains[sDomain] = dWorker
-	//在tcpProxy注册
-	err := p.server.registerDomain(sDomain, dWorker)
-	if err != nil {
-		outData.Args["msg"] = []byte("注册域名失败：" + err.Error())
-		p.tmvc.Write(outData)
-		return
-	}
-	outData.Args["msg"] = []byte("成功注册域名：" + sDomain)
-	p.tmvc.Write(outData)
-	return
-}
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"pointTest/tcpProxy/tcpmvc"
+)
+
+//后端通过client/proxy_mvc.go中的proxyClient调用本类型的方法，修改方法后重新运行go generate
+//
+//go:generate go run ./tcpmvc/mvcgen -type tcpWorker -exclude Welcome -client client/proxy_mvc.go -client-type proxyClient
+type tcpWorker struct {
+	conn   net.Conn
+	server *ProxyServer //所属的ProxyServer
+	tmvc   *tcpmvc.Mvc  //所关联的mvc对象
+}
+
+func NewTcpWorker() *tcpWorker {
+	return &tcpWorker{}
+}
+
+//来自后端的普通消息
+func (p *tcpWorker) Message(args map[string][]byte) {
+	fmt.Printf("来自后端消息：%s\n", args["msg"])
+}
+
+func (p *tcpWorker) Welcome() {
+	err := backendClient{p.tmvc}.Message(map[string][]byte{"msg": []byte("show me domain")})
+	if err != nil {
+		fmt.Println("向后端写入数据失败" + err.Error())
+	} else {
+		fmt.Println("向后端写入数据成功")
+	}
+}
+
+//来自后端的HTTP回复
+func (p *tcpWorker) HttpResponse(args map[string][]byte) {
+	domain, ok := args["domain"]
+	if !ok {
+		fmt.Println("tcpWorker-HttpResponse:索引domain无法找到")
+		return
+	}
+	worker, ok := p.server.registry.DomainWorker(p, string(domain))
+	if !ok {
+		fmt.Printf("tcpWorker-HttpResponse:无该域名（%s）代理\n", domain)
+		return
+	}
+	worker.httpResponse(args)
+}
+
+//domain注册，结果同时以消息回复后端；后端用CallRegister调用时还会收到返回的错误
+func (p *tcpWorker) Register(args map[string][]byte) error {
+	outData := backendClient{p.tmvc}.NewMessage(nil)
+	domain, ok := args["domain"]
+	if !ok {
+		outData.Args["msg"] = []byte("参数缺少domain")
+		p.tmvc.Write(outData)
+		return errors.New("参数缺少domain")
+	}
+	sDomain := string(domain)
+	config := p.server.Config()
+	if !config.allowToken(sDomain, string(args["token"])) {
+		outData.Args["msg"] = []byte("注册域名失败：token无效：" + sDomain)
+		p.tmvc.Write(outData)
+		return errors.New("token无效：" + sDomain)
+	}
+	var maxBackends int
+	if d := config.domain(sDomain); d != nil {
+		maxBackends = d.MaxBackends
+	}
+	_, err := p.server.registry.Register(p, sDomain, maxBackends)
+	if err != nil {
+		outData.Args["msg"] = []byte("注册域名失败：" + err.Error() + "：" + sDomain)
+		p.tmvc.Write(outData)
+		return fmt.Errorf("%w：%s", err, sDomain)
+	}
+	outData.Args["msg"] = []byte("成功注册域名：" + sDomain)
+	p.tmvc.Write(outData)
+	return nil
+}
+
+//注销domain，不再向本后端分发该域名的新请求，进行中的请求仍正常完成
+func (p *tcpWorker) Unregister(args map[string][]byte) error {
+	domain, ok := args["domain"]
+	if !ok {
+		return errors.New("参数缺少domain")
+	}
+	err := p.server.registry.Unregister(p, string(domain))
+	if err != nil {
+		return fmt.Errorf("%w：%s", err, domain)
+	}
+	return nil
+}
+
+//本后端已注册的域名，按字典序排列
+func (p *tcpWorker) ListRegistrations() []string {
+	return p.server.registry.Domains(p)
+}
@@ -0,0 +1,86 @@
+package tcpmvc
+
+import (
+	"encoding/json"
+	"time"
+)
+
+const (
+	maxBatchCount = 64        //一个批量帧最多包含的Data数量
+	maxBatchSize  = 32 * 1024 //一个批量帧的数据达到这么大时立即发送
+)
+
+//等待合并发送的一批Data
+type batch struct {
+	datas [][]byte //已编码的Data
+	size  int
+	done  chan struct{} //发送完成后关闭
+	err   error
+}
+
+//把已编码的Data加入当前批次，等待批次发送完成
+func (m *Mvc) writeBatched(data []byte) error {
+	m.bmu.Lock()
+	b := m.pending
+	if b == nil {
+		b = &batch{done: make(chan struct{})}
+		m.pending = b
+		time.AfterFunc(m.BatchWindow, func() {
+			m.flushBatch(b)
+		})
+	}
+	b.datas = append(b.datas, data)
+	b.size += len(data)
+	full := len(b.datas) >= maxBatchCount || b.size >= maxBatchSize
+	m.bmu.Unlock()
+	if full {
+		m.flushBatch(b)
+	}
+	<-b.done
+	return b.err
+}
+
+//发送批次，只有一个Data时按普通帧发送
+func (m *Mvc) flushBatch(b *batch) {
+	m.bmu.Lock()
+	if m.pending != b {
+		//已由其他调用发送
+		m.bmu.Unlock()
+		return
+	}
+	m.pending = nil
+	m.bmu.Unlock()
+	if len(b.datas) == 1 {
+		b.err = m.writeFrame(TAG, nil, b.datas[0])
+	} else {
+		buf := encodePool.Get().(*encodeBuffer)
+		buf.Reset()
+		buf.WriteByte('[')
+		for i, data := range b.datas {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			buf.Write(data)
+		}
+		buf.WriteByte(']')
+		b.err = m.writeFrame(BATCH_TAG, nil, buf.Bytes())
+		putEncodeBuffer(buf)
+	}
+	close(b.done)
+}
+
+//处理批量帧，逐个分发其中的Data
+func (m *Mvc) handleBatch(raw []byte) error {
+	var datas []*Data
+	err := json.Unmarshal(raw, &datas)
+	if err != nil {
+		go m.writeError(CodeBadData, err.Error(), nil)
+		return newError(CodeBadData, "BATCH:", err)
+	}
+	for _, data := range datas {
+		if data != nil {
+			m.dispatch(data)
+		}
+	}
+	return nil
+}
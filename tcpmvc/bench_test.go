@@ -0,0 +1,199 @@
+package tcpmvc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func benchData() *Data {
+	data := NewData()
+	data.Model = "tcpWorker"
+	data.Method = "Message"
+	data.Args["msg"] = []byte("show me domain")
+	return data
+}
+
+//改写前的写法：json.Marshal、bytes.Buffer与反射的binary.Write，作为对比
+func BenchmarkWriteBinaryWrite(b *testing.B) {
+	conn := discardConn{}
+	data := benchData()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		js, err := json.Marshal(data)
+		if err != nil {
+			b.Fatal(err)
+		}
+		buff := bytes.NewBuffer([]byte{})
+		binary.Write(buff, binary.LittleEndian, []byte(TAG))
+		binary.Write(buff, binary.LittleEndian, int32(len(js)))
+		binary.Write(buff, binary.LittleEndian, js)
+		conn.Write(buff.Bytes())
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	m := New(discardConn{})
+	data := benchData()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := m.Write(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWriteStreamChunk(b *testing.B) {
+	m := New(discardConn{})
+	chunk := make([]byte, streamChunk)
+	b.SetBytes(int64(len(chunk)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := m.writeStreamFrame(1, true, streamData, chunk); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+//重复提供同一段数据的Reader
+type loopReader struct {
+	data []byte
+	off  int
+}
+
+func (r *loopReader) Read(p []byte) (int, error) {
+	n := copy(p, r.data[r.off:])
+	r.off = (r.off + n) % len(r.data)
+	return n, nil
+}
+
+func BenchmarkDecoder(b *testing.B) {
+	js, _ := json.Marshal(benchData())
+	f, _ := EncodeFrame(TAG, js)
+	d := NewDecoder(&loopReader{data: bytes.Repeat(f, 64)})
+	b.SetBytes(int64(len(f)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := d.Next(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestWriteAllocs(t *testing.T) {
+	m := New(discardConn{})
+	chunk := make([]byte, 1024)
+	allocs := testing.AllocsPerRun(100, func() {
+		m.writeStreamFrame(1, true, streamData, chunk)
+	})
+	if allocs > 0 {
+		t.Fatalf("stream frame write allocates %.1f times", allocs)
+	}
+
+	js, _ := json.Marshal(benchData())
+	f, _ := EncodeFrame(TAG, js)
+	d := NewDecoder(&loopReader{data: bytes.Repeat(f, 8)})
+	d.Next()
+	allocs = testing.AllocsPerRun(100, func() {
+		d.Next()
+	})
+	if allocs > 0 {
+		t.Fatalf("decoder allocates %.1f times per frame", allocs)
+	}
+}
+
+func (c *invokeModel) Bench(args map[string][]byte) {}
+
+func (c *invokeModel) BenchResult(args map[string][]byte) []string { return nil }
+
+//改写前的分发方式：两次map查找加reflect.Value.Call
+func BenchmarkDispatchReflect(b *testing.B) {
+	m := New(discardConn{})
+	m.Include(&invokeModel{})
+	data := benchData()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		method := m.Models["invokeModel"]["Bench"]
+		method.Call([]reflect.Value{reflect.ValueOf(data.Args)})
+	}
+}
+
+//Include普通的控制器，常见签名直接调用，不应慢于改写前的反射分发
+func BenchmarkDispatchInclude(b *testing.B) {
+	m := New(discardConn{})
+	m.Include(&invokeModel{})
+	data := benchData()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.invokers["invokeModel"]["Bench"](data)
+	}
+}
+
+//不属于常见签名的方法仍经过反射
+func BenchmarkDispatchCompiled(b *testing.B) {
+	m := New(discardConn{})
+	m.Include(&invokeModel{})
+	data := benchData()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.invokers["invokeModel"]["BenchResult"](data)
+	}
+}
+
+//与mvcgen生成的控制器相同，Include时通过RegisterHandlers注册
+type genModel struct{}
+
+func (c *genModel) Bench(args map[string][]byte) {}
+
+func (c *genModel) RegisterHandlers(m *Mvc) {
+	m.Handle("genModel", "Bench", c.Bench)
+}
+
+//代理与客户端的注册方式：Include由mvcgen生成的控制器
+func BenchmarkDispatchGenerated(b *testing.B) {
+	m := New(discardConn{})
+	m.Include(&genModel{})
+	data := benchData()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.invokers["genModel"]["Bench"](data)
+	}
+}
+
+func BenchmarkDispatchHandle(b *testing.B) {
+	m := New(discardConn{})
+	m.Handle("invokeModel", "Bench", (&invokeModel{}).Bench)
+	data := benchData()
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		m.invokers["invokeModel"]["Bench"](data)
+	}
+}
+
+//Include普通的与生成的控制器时都与Handle一样不分配内存，反射分发每次都要分配参数切片
+func TestDispatchAllocs(t *testing.T) {
+	m := New(discardConn{})
+	m.Include(&genModel{})
+	m.Include(&invokeModel{})
+	data := benchData()
+	allocs := testing.AllocsPerRun(100, func() {
+		m.invokers["genModel"]["Bench"](data)
+	})
+	if allocs > 0 {
+		t.Fatalf("generated dispatch allocates %.1f times", allocs)
+	}
+	allocs = testing.AllocsPerRun(100, func() {
+		m.invokers["invokeModel"]["Bench"](data)
+	})
+	if allocs > 0 {
+		t.Fatalf("included dispatch allocates %.1f times", allocs)
+	}
+	reflectAllocs := testing.AllocsPerRun(100, func() {
+		m.Models["invokeModel"]["Bench"].Call([]reflect.Value{reflect.ValueOf(data.Args)})
+	})
+	if reflectAllocs == 0 {
+		t.Fatal("reflect dispatch should allocate")
+	}
+}
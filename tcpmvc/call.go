@@ -0,0 +1,157 @@
+package tcpmvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+//调用对端方法并等待返回值：请求的Meta带有call-id，对端处理方法返回后回复一个Meta带有reply-to的Data
+//回复的Args中result为返回值的JSON，error为RemoteError的JSON
+//旧版本的对端不会回复，调用方应通过ctx设置超时
+const (
+	MetaCallID  = "call-id"
+	MetaReplyTo = "reply-to"
+)
+
+//处理方法返回后回复调用方，只回复一次
+type replier interface {
+	reply(result interface{}, err error)
+}
+
+//本端等待回复的调用
+type pendingCalls struct {
+	mu    sync.Mutex
+	next  uint64
+	calls map[string]chan *Data
+}
+
+//调用对端的model.method，返回对端处理方法的返回值
+//对端返回错误或分发失败时返回*RemoteError，ctx结束或连接断开时返回对应的错误
+func (m *Mvc) Call(ctx context.Context, data *Data) (json.RawMessage, error) {
+	if dl, ok := ctx.Deadline(); ok && data.Deadline == 0 {
+		data.SetDeadline(dl)
+	}
+	p := &m.calls
+	p.mu.Lock()
+	if p.calls == nil {
+		p.calls = make(map[string]chan *Data)
+	}
+	p.next++
+	id := strconv.FormatUint(p.next, 10)
+	ch := make(chan *Data, 1)
+	p.calls[id] = ch
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.calls, id)
+		p.mu.Unlock()
+	}()
+
+	data.SetMeta(MetaCallID, id)
+	err := m.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case reply := <-ch:
+		if raw, ok := reply.Args["error"]; ok {
+			rerr := &RemoteError{}
+			if err := json.Unmarshal(raw, rerr); err != nil {
+				return nil, newError(CodeBadData, "error:", err)
+			}
+			return nil, rerr
+		}
+		return reply.Args["result"], nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-m.closed:
+		return nil, ErrTCPLose
+	}
+}
+
+//收到的回复交给等待的调用，调用已结束时丢弃
+func (m *Mvc) deliver(id string, data *Data) {
+	p := &m.calls
+	p.mu.Lock()
+	ch, ok := p.calls[id]
+	p.mu.Unlock()
+	if !ok {
+		return
+	}
+	//重复的回复丢弃
+	select {
+	case ch <- data:
+	default:
+	}
+}
+
+//对端通过Call发来的调用
+type remoteCall struct {
+	m    *Mvc
+	data *Data
+	done int32
+}
+
+func (c *remoteCall) reply(result interface{}, err error) {
+	if !atomic.CompareAndSwapInt32(&c.done, 0, 1) {
+		return
+	}
+	reply := NewData()
+	reply.Model = c.data.Model
+	reply.Method = c.data.Method
+	reply.SetMeta(MetaReplyTo, c.data.GetMeta(MetaCallID))
+	if err == nil {
+		reply.Args["result"], err = rpcResult(result)
+	}
+	if err != nil {
+		//分发时的错误已是RemoteError，处理方法返回的错误保留其错误码
+		rerr, ok := err.(*RemoteError)
+		if !ok {
+			rerr = &RemoteError{Code: CodeHandlerError, Message: err.Error(), Model: c.data.Model, Method: c.data.Method}
+			var perr *ProtocolError
+			var rpcErr *RPCError
+			if errors.As(err, &perr) {
+				rerr.Code = perr.Code
+			} else if errors.As(err, &rpcErr) {
+				//处理方法指定的错误码原样传回
+				rerr.Code, rerr.Message = Code(rpcErr.Code), rpcErr.Message
+			}
+		}
+		reply.Args["error"], _ = json.Marshal(rerr)
+	}
+	c.m.Write(reply)
+}
+
+//本端直接分发的调用，回复通过通道返回
+type localCall struct {
+	ch   chan localReply
+	done int32
+}
+
+type localReply struct {
+	result interface{}
+	err    error
+}
+
+func (c *localCall) reply(result interface{}, err error) {
+	if atomic.CompareAndSwapInt32(&c.done, 0, 1) {
+		c.ch <- localReply{result, err}
+	}
+}
+
+//在本端分发Data并等待处理方法返回，不经过连接，拦截器同样生效
+func (m *Mvc) Invoke(ctx context.Context, data *Data) (interface{}, error) {
+	call := &localCall{ch: make(chan localReply, 1)}
+	data.call = call
+	m.dispatch(data)
+	select {
+	case r := <-call.ch:
+		return r.result, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,48 @@
+package tcpmvc
+
+import (
+	"strings"
+	"sync/atomic"
+)
+
+//协议扩展：本端在发出的第一个Data的Meta中告知支持的扩展，对端未告知的扩展不使用
+//改写前的对端只认识mvc|与tag|帧，收到bat|或stm|帧会断开连接，也不会告知任何扩展
+const (
+	MetaCaps = "caps" //发送方支持的扩展，以逗号分隔
+
+	CapBatch  = "batch"  //可以接收bat|批量帧
+	CapStream = "stream" //可以接收stm|流帧
+)
+
+//本端支持的扩展
+var localCaps = strings.Join([]string{CapBatch, CapStream}, ",")
+
+//发出的第一个Data带上本端支持的扩展
+func (m *Mvc) announceCaps(data *Data) {
+	if atomic.LoadInt32(&m.announced) == 0 && atomic.CompareAndSwapInt32(&m.announced, 0, 1) {
+		data.SetMeta(MetaCaps, localCaps)
+	}
+}
+
+//记录对端告知的扩展
+func (m *Mvc) readCaps(data *Data) {
+	caps, ok := data.Meta[MetaCaps]
+	if !ok {
+		return
+	}
+	m.cmu.Lock()
+	m.peerCaps = strings.Split(caps, ",")
+	m.cmu.Unlock()
+}
+
+//对端是否告知支持该扩展，尚未收到对端的Data时返回false
+func (m *Mvc) PeerSupports(capability string) bool {
+	m.cmu.RLock()
+	defer m.cmu.RUnlock()
+	for _, c := range m.peerCaps {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package tcpmvc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+//抓包文件中的一条记录，每行一个JSON
+type CaptureRecord struct {
+	Time    time.Time `json:"time"`
+	Conn    string    `json:"conn,omitempty"` //所属连接，通常为对端地址
+	Dir     string    `json:"dir"`            //in:收到的帧，out:发出的帧
+	Tag     string    `json:"tag"`
+	Payload []byte    `json:"payload"`
+}
+
+func (d Direction) String() string {
+	if d == Inbound {
+		return "in"
+	}
+	return "out"
+}
+
+//把收发的每一帧写入抓包文件，多个连接可共用同一个Capture
+type Capture struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	err error //第一次写入失败的错误，之后不再写入
+}
+
+func NewCapture(w io.Writer) *Capture {
+	return &Capture{enc: json.NewEncoder(w)}
+}
+
+//返回设置到Mvc.Hook的帧观察者，conn用于区分不同的连接
+func (c *Capture) Hook(conn string) FrameHook {
+	return func(dir Direction, tag string, payload []byte) {
+		rec := CaptureRecord{Time: time.Now(), Conn: conn, Dir: dir.String(), Tag: tag, Payload: payload}
+		c.mu.Lock()
+		if c.err == nil {
+			c.err = c.enc.Encode(&rec)
+		}
+		c.mu.Unlock()
+	}
+}
+
+//写入抓包文件时遇到的错误
+func (c *Capture) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.err
+}
+
+//逐条读取抓包文件，fn返回错误时停止
+func ReadCapture(r io.Reader, fn func(rec *CaptureRecord) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var rec CaptureRecord
+		err := json.Unmarshal(scanner.Bytes(), &rec)
+		if err != nil {
+			return errors.New("capture:" + err.Error())
+		}
+		err = fn(&rec)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+//把抓包文件中收到的帧交给m处理，复现当时对端发来的消息
+//conn不为空时只重放该连接的帧；realtime为true时按记录的时间间隔重放
+//与StartHandle相同，由重放的第一帧确定对端的协议；m没有连接时丢弃处理方法的回复
+func Replay(r io.Reader, m *Mvc, conn string, realtime bool) error {
+	if m.conn == nil {
+		m.conn = discardConn{}
+	}
+	var last time.Time
+	first := true
+	return ReadCapture(r, func(rec *CaptureRecord) error {
+		if rec.Dir != Inbound.String() || (conn != "" && rec.Conn != conn) {
+			return nil
+		}
+		if realtime && !last.IsZero() && rec.Time.After(last) {
+			time.Sleep(rec.Time.Sub(last))
+		}
+		last = rec.Time
+		if m.Hook != nil {
+			m.Hook(Inbound, rec.Tag, rec.Payload)
+		}
+		if first {
+			m.detectRPC(rec.Tag)
+			first = false
+		}
+		return m.handleFrame(rec.Tag, rec.Payload)
+	})
+}
+
+//丢弃所有写入的连接，重放时代替对端
+type discardConn struct {
+	net.Conn
+}
+
+func (discardConn) Write(b []byte) (int, error) {
+	return len(b), nil
+}
@@ -0,0 +1,121 @@
+package tcpmvc
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+type capturer struct {
+	got chan string
+}
+
+func (c *capturer) Message(args map[string][]byte) {
+	c.got <- string(args["msg"])
+}
+
+func TestCaptureReplay(t *testing.T) {
+	a, b := tcpPair(t)
+	var buf bytes.Buffer
+	capture := NewCapture(&buf)
+	b.Hook = capture.Hook("b")
+	c := &capturer{got: make(chan string, 2)}
+	b.Include(c)
+
+	for _, msg := range []string{"one", "two"} {
+		data := NewData()
+		data.Model = "capturer"
+		data.Method = "Message"
+		data.Args["msg"] = []byte(msg)
+		if err := a.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		<-c.got
+	}
+	if err := capture.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	//重放到一个新的Mvc
+	local, remote := net.Pipe()
+	defer local.Close()
+	defer remote.Close()
+	go io.Copy(ioutil.Discard, remote)
+	m := New(local)
+	replayed := &capturer{got: make(chan string, 2)}
+	m.Include(replayed)
+	if err := Replay(bytes.NewReader(buf.Bytes()), m, "b", false); err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-replayed.got:
+			got[msg] = true
+		case <-time.After(time.Second):
+			t.Fatal("replay did not dispatch")
+		}
+	}
+	if !got["one"] || !got["two"] {
+		t.Fatalf("replayed %v", got)
+	}
+}
+
+//重放JSON-RPC的抓包到没有连接的Mvc：与StartHandle一样由第一帧确定协议，回复被丢弃而不是panic
+func TestReplayJSONRPC(t *testing.T) {
+	var buf bytes.Buffer
+	hook := NewCapture(&buf).Hook("c")
+	hook(Inbound, RPC_TAG, []byte(`{"jsonrpc":"2.0","method":"rpcModel.Missing","id":1}`))
+	hook(Inbound, RPC_TAG, []byte(`{"jsonrpc":"2.0","method":"rpcModel.Notify","params":{"msg":"hi"}}`))
+
+	m := New(nil)
+	r := &rpcModel{m: m, notified: make(chan string, 1)}
+	m.Include(r)
+	var mu sync.Mutex
+	var sent []string
+	m.Hook = func(dir Direction, tag string, payload []byte) {
+		if dir == Outbound {
+			mu.Lock()
+			sent = append(sent, tag)
+			mu.Unlock()
+		}
+	}
+	if err := Replay(bytes.NewReader(buf.Bytes()), m, "", false); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-r.notified:
+		if msg != "hi" {
+			t.Fatalf("got %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("replay did not dispatch")
+	}
+	if !m.JSONRPC() {
+		t.Fatal("JSON-RPC not detected from the first replayed frame")
+	}
+	//未知方法的错误回复与Notify发出的通知
+	deadline := time.Now().Add(time.Second)
+	for {
+		mu.Lock()
+		n := len(sent)
+		tags := append([]string(nil), sent...)
+		mu.Unlock()
+		if n == 2 {
+			for _, tag := range tags {
+				if tag != RPC_TAG {
+					t.Fatalf("replay wrote %v", tags)
+				}
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("replay wrote %v", tags)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,159 @@
+package tcpmvc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"sync/atomic"
+)
+
+//单帧默认最大长度，超过视为数据损坏
+const DefaultMaxFrame = 64 * 1024 * 1024
+
+//帧解码器，从io.Reader中逐帧读取 TAG+数据长度+数据本身，同时支持旧版本的LEGACY_TAG
+//开启Resync后遇到未知标识或损坏的帧不会返回错误，而是向后查找下一个有效标识，并统计丢弃的字节数
+//数据以{或[开头时视为按行分隔的JSON-RPC，之后每行作为一个RPC_TAG帧返回
+type Decoder struct {
+	r        *bufio.Reader
+	Resync   bool   //遇到损坏的数据时向后查找下一个有效标识
+	MaxFrame uint32 //单帧最大长度，为0时使用DefaultMaxFrame
+	dropped  uint64 //因损坏丢弃的字节数
+	buf      []byte //复用的读取缓冲区
+	started  bool   //已读取过数据，只在开头判断是否按行分隔
+	lines    bool   //按行分隔的JSON-RPC
+}
+
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+//读取下一帧，返回帧标识与帧数据
+//返回的帧数据在下一次调用Next之前有效，需要保留时应复制
+//在帧边界上遇到连接关闭时返回io.EOF，帧读取到一半时返回数据不完整的错误
+func (d *Decoder) Next() (string, []byte, error) {
+	maxFrame := d.MaxFrame
+	if maxFrame == 0 {
+		maxFrame = DefaultMaxFrame
+	}
+	if !d.started {
+		d.started = true
+		first, err := d.r.Peek(1)
+		if err == nil && (first[0] == '{' || first[0] == '[') {
+			d.lines = true
+		}
+	}
+	if d.lines {
+		return d.nextLine(maxFrame)
+	}
+	for {
+		head, err := d.r.Peek(N_TAG + 4)
+		if err != nil {
+			if err == io.EOF {
+				if len(head) == 0 {
+					return "", nil, io.EOF
+				}
+				if d.Resync {
+					//剩余的数据不足一个帧头，只能丢弃
+					d.discard(len(head))
+					return "", nil, io.EOF
+				}
+				return "", nil, ErrDataLengthLost
+			}
+			return "", nil, newError(CodeReadError, "", err)
+		}
+		tag := tagOf(head[:N_TAG])
+		if tag == "" {
+			if !d.Resync {
+				return "", nil, newError(CodeUnkonwTag, string(head[:N_TAG]), nil)
+			}
+			d.discard(1)
+			continue
+		}
+		lbody := payloadLength(tag, binary.LittleEndian.Uint32(head[N_TAG:]))
+		if lbody == 0 || lbody > maxFrame {
+			if !d.Resync {
+				if lbody == 0 {
+					return "", nil, ErrDataLengthZero
+				}
+				return "", nil, newError(CodeDataLengthError, "帧长度"+strconv.Itoa(int(lbody)), nil)
+			}
+			d.discard(1)
+			continue
+		}
+		d.r.Discard(N_TAG + 4)
+		var raw []byte
+		if lbody <= maxReuseFrame {
+			if uint32(cap(d.buf)) < lbody {
+				d.buf = make([]byte, maxReuseFrame)
+			}
+			raw = d.buf[:lbody]
+		} else {
+			raw = make([]byte, lbody)
+		}
+		_, err = io.ReadFull(d.r, raw)
+		if err != nil {
+			if err == io.ErrUnexpectedEOF || err == io.EOF {
+				return "", nil, ErrDataLengthError
+			}
+			return "", nil, newError(CodeReadError, "", err)
+		}
+		return tag, raw, nil
+	}
+}
+
+//读取下一个非空行，行尾的换行与空白不包含在返回的数据中
+func (d *Decoder) nextLine(maxFrame uint32) (string, []byte, error) {
+	if cap(d.buf) > maxReuseFrame {
+		d.buf = nil
+	}
+	for {
+		d.buf = d.buf[:0]
+		for {
+			chunk, err := d.r.ReadSlice('\n')
+			d.buf = append(d.buf, chunk...)
+			if uint32(len(d.buf)) > maxFrame {
+				return "", nil, newError(CodeDataLengthError, "行长度超过"+strconv.Itoa(int(maxFrame)), nil)
+			}
+			if err == nil {
+				break
+			}
+			if err == bufio.ErrBufferFull {
+				continue
+			}
+			if err == io.EOF {
+				//最后一行可以没有换行
+				if len(bytes.TrimSpace(d.buf)) == 0 {
+					return "", nil, io.EOF
+				}
+				break
+			}
+			return "", nil, newError(CodeReadError, "", err)
+		}
+		line := bytes.TrimSpace(d.buf)
+		if len(line) > 0 {
+			return RPC_TAG, line, nil
+		}
+	}
+}
+
+//是否是按行分隔的JSON-RPC，读取第一帧之后才能确定
+func (d *Decoder) Lines() bool {
+	return d.lines
+}
+
+func (d *Decoder) discard(n int) {
+	n, _ = d.r.Discard(n)
+	d.Drop(n)
+}
+
+//记录因损坏被丢弃的字节数，帧能分隔但内容无法解析时也由调用方计入
+func (d *Decoder) Drop(n int) {
+	atomic.AddUint64(&d.dropped, uint64(n))
+}
+
+//因损坏被丢弃的字节数
+func (d *Decoder) Dropped() uint64 {
+	return atomic.LoadUint64(&d.dropped)
+}
@@ -0,0 +1,153 @@
+package tcpmvc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func frame(tag string, payload string) []byte {
+	b := make([]byte, N_TAG+4+len(payload))
+	copy(b, tag)
+	binary.LittleEndian.PutUint32(b[N_TAG:], uint32(len(payload)))
+	copy(b[N_TAG+4:], payload)
+	return b
+}
+
+func TestDecoderResync(t *testing.T) {
+	var buf bytes.Buffer
+	buf.Write(frame(TAG, "one"))
+	buf.WriteString("garbage")
+	buf.Write(frame("bad|", "xx"))
+	buf.Write(frame(TAG, "two"))
+	buf.WriteString("mv")
+
+	d := NewDecoder(&buf)
+	d.Resync = true
+	var got []string
+	for {
+		tag, raw, err := d.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tag != TAG {
+			t.Fatalf("tag %q", tag)
+		}
+		got = append(got, string(raw))
+	}
+	if len(got) != 2 || got[0] != "one" || got[1] != "two" {
+		t.Fatalf("got %q", got)
+	}
+	want := uint64(len("garbage") + len(frame("bad|", "xx")) + len("mv"))
+	if d.Dropped() != want {
+		t.Fatalf("dropped %d, want %d", d.Dropped(), want)
+	}
+}
+
+func TestDecoderStrict(t *testing.T) {
+	d := NewDecoder(bytes.NewReader(frame("bad|", "xx")))
+	if _, _, err := d.Next(); err == nil || err == io.EOF {
+		t.Fatalf("unknown tag: %v", err)
+	}
+
+	//帧读取到一半连接关闭
+	half := frame(TAG, "truncated")
+	d = NewDecoder(bytes.NewReader(half[:len(half)-3]))
+	if _, _, err := d.Next(); err == nil || err == io.EOF {
+		t.Fatalf("truncated frame: %v", err)
+	}
+
+	d = NewDecoder(bytes.NewReader(nil))
+	if _, _, err := d.Next(); err != io.EOF {
+		t.Fatalf("empty stream: %v", err)
+	}
+}
+
+func TestStartHandleEOF(t *testing.T) {
+	local, remote := net.Pipe()
+	m := New(local)
+	done := make(chan error)
+	go func() {
+		done <- m.StartHandle()
+	}()
+	remote.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("clean close returned %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("StartHandle did not return on EOF")
+	}
+}
+
+func TestStartHandleResync(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	m := New(local)
+	m.Resync = true
+	c := &capturer{got: make(chan string, 1)}
+	m.Include(c)
+	go m.StartHandle()
+
+	remote.Write([]byte("noise"))
+	remote.Write(frame(TAG, "{not json"))
+	remote.Write(frame(TAG, `{"Model":"capturer","Method":"Message","Args":{"msg":"b2s="}}`))
+	select {
+	case msg := <-c.got:
+		if msg != "ok" {
+			t.Fatalf("got %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("message after corruption was not dispatched")
+	}
+	if m.Dropped() == 0 {
+		t.Fatal("dropped bytes not counted")
+	}
+}
+
+func TestLegacyPeer(t *testing.T) {
+	local, remote := net.Pipe()
+	defer remote.Close()
+	m := New(local)
+	m.Include(&legacyEcho{m: m})
+	go m.StartHandle()
+
+	//旧版本的数据长度比实际多1
+	msg := `{"Model":"legacyEcho","Method":"Ping","Args":{}}`
+	old := frame(LEGACY_TAG, msg)
+	binary.LittleEndian.PutUint32(old[N_TAG:], uint32(len(msg)+1))
+	remote.Write(old)
+
+	d := NewDecoder(remote)
+	tag, raw, err := d.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != LEGACY_TAG || !m.Legacy() {
+		t.Fatalf("reply tag %q, legacy %v", tag, m.Legacy())
+	}
+	if string(raw) != `{"Model":"legacyEcho","Method":"Pong","Args":{}}` {
+		t.Fatalf("reply %s", raw)
+	}
+	if _, err := m.OpenStream(); err == nil {
+		t.Fatal("legacy peer should not support streams")
+	}
+}
+
+type legacyEcho struct {
+	m *Mvc
+}
+
+func (e *legacyEcho) Ping(args map[string][]byte) {
+	data := NewData()
+	data.Model = "legacyEcho"
+	data.Method = "Pong"
+	e.m.Write(data)
+}
@@ -0,0 +1,183 @@
+package tcpmvc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+//错误码，数值固定不变，随错误帧发送给对端，其他语言的客户端可按错误码处理
+type Code int
+
+const (
+	CodeTCPLose          Code = 1
+	CodeReadError        Code = 2
+	CodeReadOver         Code = 3
+	CodeWriteFail        Code = 4
+	CodeWriteLengthError Code = 5
+	CodeUnkonwTag        Code = 6
+	CodeDataLengthLost   Code = 7
+	CodeDataLengthZero   Code = 8
+	CodeDataLengthError  Code = 9
+	CodeUnkonwModel      Code = 10
+	CodeUnkonwMethod     Code = 11
+	CodeStreamClosed     Code = 12
+	CodeStreamReset      Code = 13
+	CodeStreamUnknow     Code = 14
+	CodeStreamOverflow   Code = 15
+	CodeLegacyPeer       Code = 16
+	CodeBadData          Code = 17 //Data无法解析
+	CodeEncodeFail       Code = 18 //Data无法编码
+	CodeDeadlineExceeded Code = 19 //Data已超过截止时间，未被处理
+	CodeHandlerError     Code = 20 //处理方法返回了错误
+	CodeRejected         Code = 21 //拦截器没有继续分发
+	CodeJSONRPCPeer      Code = 22 //按行分隔的JSON-RPC连接只能发送JSON-RPC消息
+)
+
+var codeStatus = map[Code]string{
+	CodeTCPLose:          StatusTCPLose,
+	CodeReadError:        StatusReadError,
+	CodeReadOver:         StatusReadOver,
+	CodeWriteFail:        StatusWriteFail,
+	CodeWriteLengthError: StatusWriteLengthError,
+	CodeUnkonwTag:        StatusUnkonwTag,
+	CodeDataLengthLost:   StatusDataLengthLost,
+	CodeDataLengthZero:   StatusDataLengthZero,
+	CodeDataLengthError:  StatusDataLengthError,
+	CodeUnkonwModel:      StatusUnkonwModel,
+	CodeUnkonwMethod:     StatusUnkonwMethod,
+	CodeStreamClosed:     StatusStreamClosed,
+	CodeStreamReset:      StatusStreamReset,
+	CodeStreamUnknow:     StatusStreamUnknow,
+	CodeStreamOverflow:   StatusStreamOverflow,
+	CodeLegacyPeer:       StatusLegacyPeer,
+	CodeBadData:          StatusBadData,
+	CodeEncodeFail:       StatusEncodeFail,
+	CodeDeadlineExceeded: StatusDeadlineExceeded,
+	CodeHandlerError:     StatusHandlerError,
+	CodeRejected:         StatusRejected,
+	CodeJSONRPCPeer:      StatusJSONRPCPeer,
+}
+
+//错误码对应的说明
+func (c Code) String() string {
+	s, ok := codeStatus[c]
+	if !ok {
+		return "错误码" + strconv.Itoa(int(c)) + ";"
+	}
+	return s
+}
+
+//本端协议处理中的错误
+//可用errors.Is与同错误码的哨兵错误比较，例如 errors.Is(err, ErrStreamReset)
+type ProtocolError struct {
+	Code   Code
+	Detail string //附加信息
+	Err    error  //底层错误
+}
+
+func (e *ProtocolError) Error() string {
+	s := e.Code.String()
+	if e.Detail != "" {
+		s += e.Detail
+	}
+	if e.Err != nil {
+		s += e.Err.Error()
+	}
+	return s
+}
+
+func (e *ProtocolError) Unwrap() error {
+	return e.Err
+}
+
+//错误码相同即视为同一错误
+func (e *ProtocolError) Is(target error) bool {
+	t, ok := target.(*ProtocolError)
+	return ok && t.Code == e.Code
+}
+
+func newError(code Code, detail string, err error) *ProtocolError {
+	return &ProtocolError{Code: code, Detail: detail, Err: err}
+}
+
+//哨兵错误，只用于errors.Is比较
+var (
+	ErrTCPLose          = &ProtocolError{Code: CodeTCPLose}
+	ErrReadError        = &ProtocolError{Code: CodeReadError}
+	ErrReadOver         = &ProtocolError{Code: CodeReadOver}
+	ErrWriteFail        = &ProtocolError{Code: CodeWriteFail}
+	ErrWriteLengthError = &ProtocolError{Code: CodeWriteLengthError}
+	ErrUnkonwTag        = &ProtocolError{Code: CodeUnkonwTag}
+	ErrDataLengthLost   = &ProtocolError{Code: CodeDataLengthLost}
+	ErrDataLengthZero   = &ProtocolError{Code: CodeDataLengthZero}
+	ErrDataLengthError  = &ProtocolError{Code: CodeDataLengthError}
+	ErrUnkonwModel      = &ProtocolError{Code: CodeUnkonwModel}
+	ErrUnkonwMethod     = &ProtocolError{Code: CodeUnkonwMethod}
+	ErrStreamClosed     = &ProtocolError{Code: CodeStreamClosed}
+	ErrStreamReset      = &ProtocolError{Code: CodeStreamReset}
+	ErrStreamUnknow     = &ProtocolError{Code: CodeStreamUnknow}
+	ErrStreamOverflow   = &ProtocolError{Code: CodeStreamOverflow}
+	ErrLegacyPeer       = &ProtocolError{Code: CodeLegacyPeer}
+	ErrBadData          = &ProtocolError{Code: CodeBadData}
+	ErrEncodeFail       = &ProtocolError{Code: CodeEncodeFail}
+	ErrDeadlineExceeded = &ProtocolError{Code: CodeDeadlineExceeded}
+	ErrHandlerError     = &ProtocolError{Code: CodeHandlerError}
+	ErrRejected         = &ProtocolError{Code: CodeRejected}
+	ErrJSONRPCPeer      = &ProtocolError{Code: CodeJSONRPCPeer}
+)
+
+//对端通过错误帧告知的错误，错误帧的数据为本结构的JSON
+type RemoteError struct {
+	Code    Code   `json:"code"`
+	Message string `json:"message"`
+	Model   string `json:"model,omitempty"` //出错的Data
+	Method  string `json:"method,omitempty"`
+}
+
+func (e *RemoteError) Error() string {
+	return fmt.Sprintf("tcpmvc:对端错误%d:%s", e.Code, e.Message)
+}
+
+//与同错误码的ProtocolError哨兵比较，例如 errors.Is(err, ErrUnkonwMethod)
+func (e *RemoteError) Is(target error) bool {
+	t, ok := target.(*ProtocolError)
+	return ok && t.Code == e.Code
+}
+
+//向对端发送错误帧，旧版本客户端不支持错误帧，不发送
+//JSON-RPC请求的错误作为该请求的回复发送，JSON-RPC通知出错时不回复
+func (m *Mvc) writeError(code Code, message string, data *Data) error {
+	if data != nil && data.call != nil {
+		data.call.reply(nil, &RemoteError{Code: code, Message: message, Model: data.Model, Method: data.Method})
+		return nil
+	}
+	if m.Legacy() || m.JSONRPC() {
+		return nil
+	}
+	rerr := RemoteError{Code: code, Message: message}
+	if data != nil {
+		rerr.Model = data.Model
+		rerr.Method = data.Method
+	}
+	payload, err := json.Marshal(&rerr)
+	if err != nil {
+		return newError(CodeEncodeFail, "", err)
+	}
+	return m.writeFrame(ERROR_TAG, nil, payload)
+}
+
+//处理收到的错误帧
+func (m *Mvc) handleError(raw []byte) error {
+	var rerr RemoteError
+	err := json.Unmarshal(raw, &rerr)
+	if err != nil {
+		return newError(CodeBadData, "ERROR:", err)
+	}
+	if m.OnError != nil {
+		m.OnError(&rerr)
+		return nil
+	}
+	fmt.Println(rerr.Error())
+	return nil
+}
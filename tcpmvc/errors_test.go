@@ -0,0 +1,52 @@
+package tcpmvc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestProtocolErrorIs(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", newError(CodeUnkonwTag, "bad|", nil))
+	if !errors.Is(err, ErrUnkonwTag) {
+		t.Fatal("errors.Is should match by code")
+	}
+	if errors.Is(err, ErrReadOver) {
+		t.Fatal("different codes should not match")
+	}
+
+	d := NewDecoder(bytes.NewReader(frame("bad|", "xx")))
+	_, _, err = d.Next()
+	var perr *ProtocolError
+	if !errors.As(err, &perr) || perr.Code != CodeUnkonwTag {
+		t.Fatalf("decoder error %v", err)
+	}
+}
+
+func TestRemoteError(t *testing.T) {
+	a, b := tcpPair(t)
+	b.Include(&capturer{})
+	got := make(chan *RemoteError, 1)
+	a.OnError = func(err *RemoteError) {
+		got <- err
+	}
+	data := NewData()
+	data.Model = "capturer"
+	data.Method = "Missing"
+	if err := a.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case rerr := <-got:
+		if rerr.Code != CodeUnkonwMethod || rerr.Model != "capturer" || rerr.Method != "Missing" {
+			t.Fatalf("got %+v", rerr)
+		}
+		if !errors.Is(rerr, ErrUnkonwMethod) {
+			t.Fatal("remote error should match sentinel by code")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no error frame received")
+	}
+}
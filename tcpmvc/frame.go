@@ -0,0 +1,173 @@
+package tcpmvc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+//每次TCP传输的数据结构由：TAG+数据长度+数据本身 组成
+//旧版本客户端使用LEGACY_TAG，数据长度比实际多1，以防数据为空时破坏数据结构
+
+const (
+	frameHeader     = N_TAG + 4 //帧头：TAG+数据长度
+	smallFrame      = 32 * 1024 //不超过这么大的帧先拼接再一次写出，更大的帧使用writev避免复制
+	maxPooledBuffer = 64 * 1024 //超过这么大的缓冲区不放回池中，避免长期占用内存
+	maxReuseFrame   = 64 * 1024 //Decoder复用读取缓冲区的帧大小上限
+	frameScratch    = frameHeader + streamHeader
+)
+
+//由帧头中的标识得到对应的常量，未知标识返回空字符串，不分配内存
+func tagOf(b []byte) string {
+	switch string(b) {
+	case TAG:
+		return TAG
+	case STREAM_TAG:
+		return STREAM_TAG
+	case LEGACY_TAG:
+		return LEGACY_TAG
+	case ERROR_TAG:
+		return ERROR_TAG
+	case BATCH_TAG:
+		return BATCH_TAG
+	case RPC_TAG:
+		return RPC_TAG
+	}
+	return ""
+}
+
+//是否是能够解析的帧标识
+func isTag(tag string) bool {
+	switch tag {
+	case TAG, STREAM_TAG, LEGACY_TAG, ERROR_TAG, BATCH_TAG, RPC_TAG:
+		return true
+	}
+	return false
+}
+
+//把帧头写入dst，l为数据长度
+func putHeader(dst []byte, tag string, l int) {
+	copy(dst, tag)
+	n := uint32(l)
+	if tag == LEGACY_TAG {
+		n++
+	}
+	binary.LittleEndian.PutUint32(dst[N_TAG:], n)
+}
+
+//编码一帧，返回 TAG+数据长度+数据本身
+func EncodeFrame(tag string, payload []byte) ([]byte, error) {
+	if !isTag(tag) {
+		return nil, newError(CodeUnkonwTag, tag, nil)
+	}
+	frame := make([]byte, frameHeader+len(payload))
+	putHeader(frame, tag, len(payload))
+	copy(frame[frameHeader:], payload)
+	return frame, nil
+}
+
+//由帧头中的数据长度得到实际数据长度
+func payloadLength(tag string, lbody uint32) uint32 {
+	if tag == LEGACY_TAG && lbody > 0 {
+		return lbody - 1
+	}
+	return lbody
+}
+
+//编码Data用的缓冲区，与json.Encoder一起复用
+type encodeBuffer struct {
+	bytes.Buffer
+	enc *json.Encoder
+}
+
+var encodePool = sync.Pool{
+	New: func() interface{} {
+		b := new(encodeBuffer)
+		b.enc = json.NewEncoder(&b.Buffer)
+		return b
+	},
+}
+
+//把Data编码为JSON，返回的数据在putEncodeBuffer之前有效
+func encodeData(data *Data) (*encodeBuffer, []byte, error) {
+	b := encodePool.Get().(*encodeBuffer)
+	b.Reset()
+	err := b.enc.Encode(data)
+	if err != nil {
+		putEncodeBuffer(b)
+		return nil, nil, newError(CodeEncodeFail, "", err)
+	}
+	//去掉Encode追加的换行
+	return b, b.Bytes()[:b.Len()-1], nil
+}
+
+func putEncodeBuffer(b *encodeBuffer) {
+	if b.Cap() <= maxPooledBuffer {
+		encodePool.Put(b)
+	}
+}
+
+//写出一个完整的帧，并发调用时各帧不会交错
+//head为帧头之后、数据之前的附加头（如流帧的流id与标志位），可以为nil
+//小帧在连接自己的缓冲区中拼接后一次写出，大帧以writev写出，均不需要额外分配
+func (m *Mvc) writeFrame(tag string, head, payload []byte) error {
+	if atomic.LoadInt32(&m.rpc) == rpcLines {
+		return m.writeLine(tag, payload)
+	}
+	l := len(head) + len(payload)
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	putHeader(m.whdr[:], tag, l)
+	n := copy(m.whdr[frameHeader:], head)
+	hdr := m.whdr[:frameHeader+n]
+	var written int64
+	var err error
+	if l <= smallFrame {
+		m.wbuf = append(append(m.wbuf[:0], hdr...), payload...)
+		var w int
+		w, err = m.conn.Write(m.wbuf)
+		written = int64(w)
+	} else {
+		bufs := net.Buffers{hdr, payload}
+		written, err = bufs.WriteTo(m.conn)
+	}
+	if err != nil {
+		return newError(CodeWriteFail, "", err)
+	}
+	if written != int64(frameHeader+l) {
+		return ErrWriteLengthError
+	}
+	if m.Hook != nil {
+		//在锁内调用，保证与实际写出的顺序一致
+		if len(head) == 0 {
+			m.Hook(Outbound, tag, payload)
+		} else {
+			m.Hook(Outbound, tag, append(append([]byte(nil), head...), payload...))
+		}
+	}
+	return nil
+}
+
+//按行分隔的JSON-RPC连接没有帧头，每条消息写为一行，其他帧无法发送
+func (m *Mvc) writeLine(tag string, payload []byte) error {
+	if tag != RPC_TAG {
+		return newError(CodeJSONRPCPeer, tag, nil)
+	}
+	m.wmu.Lock()
+	defer m.wmu.Unlock()
+	m.wbuf = append(append(m.wbuf[:0], payload...), '\n')
+	n, err := m.conn.Write(m.wbuf)
+	if err != nil {
+		return newError(CodeWriteFail, "", err)
+	}
+	if n != len(m.wbuf) {
+		return ErrWriteLengthError
+	}
+	if m.Hook != nil {
+		m.Hook(Outbound, tag, payload)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package tcpmvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+//网关的默认超时
+const DefaultGatewayTimeout = 10 * time.Second
+
+//请求体的最大长度
+const maxGatewayBody = 1 << 20
+
+//把HTTP请求转为对控制器方法的调用，便于用curl调试：
+//
+//	curl -X POST -d '{"msg":"hi"}' http://127.0.0.1:7200/tcpWorker/Message
+//
+//路径为/{model}/{method}，只接受POST；请求体为JSON对象，与查询参数一起转为Args，
+//转换规则与JSON-RPC的params相同：字符串值取其内容，其他值保留JSON文本
+//成功时返回200与{"result":返回值}，失败时返回{"error":{"code":错误码,"message":说明}}
+type Gateway struct {
+	Mvc     *Mvc
+	Remote  bool          //为true时通过Call调用Mvc连接的对端，否则调用本端Include的方法
+	Timeout time.Duration //等待返回值的时间，为0时使用DefaultGatewayTimeout
+}
+
+type gatewayReply struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *RPCError       `json:"error,omitempty"`
+}
+
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		gatewayError(w, http.StatusMethodNotAllowed, &RPCError{Code: RPCInvalidRequest, Message: "只接受POST"})
+		return
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		gatewayError(w, http.StatusNotFound, &RPCError{Code: RPCMethodNotFound, Message: "路径应为/{model}/{method}"})
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxGatewayBody))
+	if err != nil {
+		gatewayError(w, http.StatusRequestEntityTooLarge, &RPCError{Code: RPCInvalidRequest, Message: err.Error()})
+		return
+	}
+	args, err := rpcArgs(body)
+	if err != nil {
+		gatewayError(w, http.StatusBadRequest, &RPCError{Code: RPCInvalidParams, Message: "请求体必须是JSON对象:" + err.Error()})
+		return
+	}
+	for k, v := range r.URL.Query() {
+		if _, ok := args[k]; !ok && len(v) > 0 {
+			args[k] = []byte(v[0])
+		}
+	}
+	data := NewData()
+	data.Model = parts[0]
+	data.Method = parts[1]
+	data.Args = args
+
+	timeout := g.Timeout
+	if timeout == 0 {
+		timeout = DefaultGatewayTimeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	var result json.RawMessage
+	if g.Remote {
+		result, err = g.Mvc.Call(ctx, data)
+	} else {
+		var v interface{}
+		v, err = g.Mvc.Invoke(ctx, data)
+		if err == nil {
+			result, err = rpcResult(v)
+		}
+	}
+	if err != nil {
+		gatewayError(w, gatewayStatus(err), rpcErrorOf(err))
+		return
+	}
+	if len(result) == 0 {
+		result = rpcNull
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(&gatewayReply{Result: result})
+}
+
+func gatewayError(w http.ResponseWriter, status int, rpcErr *RPCError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&gatewayReply{Error: rpcErr})
+}
+
+//错误对应的HTTP状态码
+func gatewayStatus(err error) int {
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrDeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.Is(err, ErrUnkonwModel), errors.Is(err, ErrUnkonwMethod):
+		return http.StatusNotFound
+	case errors.Is(err, ErrRejected):
+		return http.StatusForbidden
+	case errors.Is(err, ErrTCPLose), errors.Is(err, ErrWriteFail):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
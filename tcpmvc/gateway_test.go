@@ -0,0 +1,78 @@
+package tcpmvc
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCall(t *testing.T) {
+	a, b := tcpPair(t)
+	b.Include(&rpcModel{m: b, notified: make(chan string, 1)})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	data := NewData()
+	data.Model = "rpcModel"
+	data.Method = "Add"
+	data.Args["a"] = []byte("20")
+	data.Args["b"] = []byte("22")
+	result, err := a.Call(ctx, data)
+	if err != nil || string(result) != "42" {
+		t.Fatalf("got %s %v", result, err)
+	}
+
+	data.Method = "Missing"
+	_, err = a.Call(ctx, data)
+	if !errors.Is(err, ErrUnkonwMethod) {
+		t.Fatalf("unknown method: %v", err)
+	}
+
+	data.Method = "Add"
+	data.Args["a"] = []byte("x")
+	_, err = a.Call(ctx, data)
+	var rerr *RemoteError
+	if !errors.As(err, &rerr) || rerr.Code != CodeHandlerError {
+		t.Fatalf("handler error: %v", err)
+	}
+}
+
+func TestGateway(t *testing.T) {
+	a, b := tcpPair(t)
+	b.Include(&rpcModel{m: b, notified: make(chan string, 1)})
+	local := httptest.NewServer(&Gateway{Mvc: b})
+	defer local.Close()
+	remote := httptest.NewServer(&Gateway{Mvc: a, Remote: true})
+	defer remote.Close()
+
+	for _, srv := range []*httptest.Server{local, remote} {
+		for _, c := range []struct {
+			path   string
+			body   string
+			status int
+			want   string
+		}{
+			{"/rpcModel/Add?b=2", `{"a":40}`, 200, `{"result":42}`},
+			{"/rpcModel/Echo", `{"k":"v"}`, 200, `{"result":{"k":"v"}}`},
+			{"/rpcModel/Missing", ``, 404, `"code":-32601`},
+			{"/rpcModel/Add", `{"a":1,"b":"x"}`, 500, `"code":1001`},
+			{"/rpcModel", ``, 404, `"code":-32601`},
+			{"/rpcModel/Add", `[1]`, 400, `"code":-32602`},
+		} {
+			resp, err := http.Post(srv.URL+c.path, "application/json", strings.NewReader(c.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.StatusCode != c.status || !strings.Contains(string(body), c.want) {
+				t.Fatalf("%s %s: %d %s", srv.URL, c.path, resp.StatusCode, body)
+			}
+		}
+	}
+}
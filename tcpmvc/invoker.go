@@ -0,0 +1,191 @@
+package tcpmvc
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+//调用控制器方法，注册时为每个方法生成一次，分发时不再查找方法与检查参数
+type invoker func(data *Data)
+
+//拦截器，在收到的Data分发给处理方法之前调用，可读取Meta做认证、追踪、日志等
+//调用next继续分发，不调用则丢弃该Data
+type Interceptor func(data *Data, next func(data *Data))
+
+var (
+	argsType  = reflect.TypeOf(map[string][]byte(nil))
+	dataType  = reflect.TypeOf((*Data)(nil))
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+	noArgFunc   = reflect.TypeOf((func())(nil))
+	argsFunc    = reflect.TypeOf((func(map[string][]byte))(nil))
+	argsErrFunc = reflect.TypeOf((func(map[string][]byte) error)(nil))
+	dataFunc    = reflect.TypeOf((func(*Data))(nil))
+	dataErrFunc = reflect.TypeOf((func(*Data) error)(nil))
+)
+
+//interface{}的内存布局，data为值的指针，函数值本身就是指针，data即函数值
+type eface struct {
+	typ, data unsafe.Pointer
+}
+
+//为指针控制器上常见签名的方法生成直接调用的invoker，其他方法返回nil，由compileInvoker经反射调用
+//reflect.Value.Method得到的函数断言为具体类型后，调用时仍经过反射，比Call还慢；
+//方法表达式(*T).M是普通函数，接收者为指针，与第一个参数为unsafe.Pointer的函数调用约定相同，
+//擦除接收者类型后即可断言为具体的函数类型直接调用
+func directInvoker(recv reflect.Value, method reflect.Method) invoker {
+	if recv.Kind() != reflect.Ptr || recv.IsNil() {
+		return nil
+	}
+	p := recv.UnsafePointer()
+	fn := method.Func.Interface()
+	code := (*eface)(unsafe.Pointer(&fn)).data
+	switch recv.Method(method.Index).Type() {
+	case noArgFunc:
+		f := *(*func(unsafe.Pointer))(unsafe.Pointer(&code))
+		return func(data *Data) {
+			f(p)
+			data.finish(nil, nil)
+		}
+	case argsFunc:
+		f := *(*func(unsafe.Pointer, map[string][]byte))(unsafe.Pointer(&code))
+		return func(data *Data) {
+			f(p, data.Args)
+			data.finish(nil, nil)
+		}
+	case argsErrFunc:
+		f := *(*func(unsafe.Pointer, map[string][]byte) error)(unsafe.Pointer(&code))
+		return func(data *Data) {
+			data.finish(nil, f(p, data.Args))
+		}
+	case dataFunc:
+		f := *(*func(unsafe.Pointer, *Data))(unsafe.Pointer(&code))
+		return func(data *Data) {
+			f(p, data)
+			data.finish(nil, nil)
+		}
+	case dataErrFunc:
+		f := *(*func(unsafe.Pointer, *Data) error)(unsafe.Pointer(&code))
+		return func(data *Data) {
+			data.finish(nil, f(p, data))
+		}
+	}
+	return nil
+}
+
+//为Include的方法生成invoker，参数可以是Args或需要读取截止时间等信息时的*Data
+//其他参数的方法无法由消息调用，返回nil
+//常见签名由directInvoker直接调用，这里处理其余的签名，每次调用经过反射
+func compileInvoker(method reflect.Value) invoker {
+	mt := method.Type()
+	finish := compileResults(mt)
+	if finish == nil {
+		return nil
+	}
+	switch {
+	case mt.NumIn() == 0:
+		return func(data *Data) {
+			finish(data, method.Call(nil))
+		}
+	case mt.NumIn() == 1 && mt.In(0) == dataType:
+		return func(data *Data) {
+			finish(data, method.Call([]reflect.Value{reflect.ValueOf(data)}))
+		}
+	case mt.NumIn() == 1 && mt.In(0) == argsType:
+		return func(data *Data) {
+			finish(data, method.Call([]reflect.Value{reflect.ValueOf(data.Args)}))
+		}
+	//命名的Args类型才需要转换
+	case mt.NumIn() == 1 && argsType.ConvertibleTo(mt.In(0)):
+		in := mt.In(0)
+		return func(data *Data) {
+			finish(data, method.Call([]reflect.Value{reflect.ValueOf(data.Args).Convert(in)}))
+		}
+	}
+	return nil
+}
+
+//处理方法可以没有返回值，或返回error、一个结果、结果与error，
+//结果作为JSON-RPC请求的回复，其他返回值的方法无法由消息调用，返回nil
+func compileResults(mt reflect.Type) func(data *Data, out []reflect.Value) {
+	switch {
+	case mt.NumOut() == 0:
+		return func(data *Data, out []reflect.Value) {
+			data.finish(nil, nil)
+		}
+	case mt.NumOut() == 1 && mt.Out(0) == errorType:
+		return func(data *Data, out []reflect.Value) {
+			data.finish(nil, valueError(out[0]))
+		}
+	case mt.NumOut() == 1:
+		return func(data *Data, out []reflect.Value) {
+			data.finish(out[0].Interface(), nil)
+		}
+	case mt.NumOut() == 2 && mt.Out(1) == errorType:
+		return func(data *Data, out []reflect.Value) {
+			data.finish(out[0].Interface(), valueError(out[1]))
+		}
+	}
+	return nil
+}
+
+func valueError(v reflect.Value) error {
+	if v.IsNil() {
+		return nil
+	}
+	return v.Interface().(error)
+}
+
+//由mvcgen为控制器生成，Include时调用RegisterHandlers直接注册各方法的处理函数，代替反射调用
+type Registrar interface {
+	RegisterHandlers(m *Mvc)
+}
+
+//直接注册model.method的处理函数，不经过反射，例如 m.Handle("tcpWorker", "Message", tcpW.Message)
+//会覆盖Include中同名的方法，需在StartHandle之前调用
+func (m *Mvc) Handle(model, method string, fn func(args map[string][]byte)) {
+	m.handle(model, method, func(data *Data) {
+		fn(data.Args)
+		data.finish(nil, nil)
+	})
+}
+
+//与Handle相同，处理函数接收整个Data
+func (m *Mvc) HandleData(model, method string, fn func(data *Data)) {
+	m.handle(model, method, func(data *Data) {
+		fn(data)
+		data.finish(nil, nil)
+	})
+}
+
+//与HandleData相同，处理函数的返回值作为调用的结果，result为nil时没有结果
+func (m *Mvc) HandleResult(model, method string, fn func(data *Data) (result interface{}, err error)) {
+	m.handle(model, method, func(data *Data) {
+		data.finish(fn(data))
+	})
+}
+
+func (m *Mvc) handle(model, method string, inv invoker) {
+	invokers, ok := m.invokers[model]
+	if !ok {
+		invokers = make(map[string]invoker)
+		m.invokers[model] = invokers
+	}
+	invokers[method] = inv
+}
+
+//添加拦截器，按添加顺序执行，需在StartHandle之前调用
+func (m *Mvc) Use(interceptors ...Interceptor) {
+	m.interceptors = append(m.interceptors, interceptors...)
+}
+
+//把拦截器与invoke组合为一次调用，没有拦截器时直接返回invoke
+func (m *Mvc) intercept(invoke invoker) invoker {
+	for i := len(m.interceptors) - 1; i >= 0; i-- {
+		ic, next := m.interceptors[i], invoke
+		invoke = func(data *Data) {
+			ic(data, next)
+		}
+	}
+	return invoke
+}
@@ -1,185 +1,413 @@
-package tcpmvc
-
-import (
-	"bytes"
-	"encoding/binary"
-	"encoding/json"
-	"errors"
-	"fmt"
-	"io"
-	"net"
-	"reflect"
-)
-
-const (
-	N_TAG int    = 4      //标识长度
-	TAG   string = "mvc|" //标识字符串，用来判断是否是支持本方法读取解析
-)
-
-const (
-	StatusTCPLose          string = "TPC连接丢失;"
-	StatusReadError        string = "读取数据失败;"
-	StatusReadOver         string = "无更多数据可获取;"
-	StatusWriteFail        string = "数据写入失败"
-	StatusWriteLengthError string = "数据写入长度错误"
-	StatusUnkonwTag        string = "无法解析此数据1;"
-	StatusDataLengthLost   string = "无法解析数据大小;"
-	StatusDataLengthZero   string = "数据长度为0;"
-	StatusDataLengthError  string = "数据读取不完整;"
-	StatusUnkonwModel      string = "未知Model"
-	StatusUnkonwMethod     string = "未知Method"
-)
-
-//一次Tcp数据
-type Data struct {
-	Model  string
-	Method string
-	Args   map[string][]byte
-}
-
-func NewData() *Data {
-	data := &Data{}
-	data.Args = make(map[string][]byte)
-	return data
-}
-
-type Mvc struct {
-	conn   *net.TCPConn
-	Models map[string]map[string]reflect.Value
-	//LoseLink   chan int //断开了连接
-	Disconnect func()
-}
-
-func New(c *net.TCPConn) *Mvc {
-	models := make(map[string]map[string]reflect.Value)
-	m := new(Mvc)
-	m.conn = c
-	m.Models = models
-	return m
-}
-
-//包含调用的struct的引用
-func (m *Mvc) Include(controller interface{}) {
-	fv := reflect.ValueOf(controller)
-	ct := reflect.Indirect(fv).Type()
-	modelName := ct.Name()
-	_, ok := m.Models[modelName]
-	if ok {
-		return
-	}
-	rt := fv.Type()
-	model := make(map[string]reflect.Value, rt.NumMethod())
-	for i := 0; i < rt.NumMethod(); i++ {
-		methodName := rt.Method(i).Name
-		model[methodName] = fv.MethodByName(methodName)
-	}
-	m.Models[modelName] = model
-}
-
-func (m *Mvc) StartHandle() error {
-	var outErr error
-	for {
-		data, err := m.read()
-		if err != nil {
-			if err.Error() == StatusReadOver {
-				continue
-			} else {
-				outErr = err
-				break
-			}
-		}
-		model, ok := m.Models[data.Model]
-		if !ok {
-			fmt.Printf("tcpmvc:%s:%s\n", StatusUnkonwModel, data.Model)
-			continue
-		}
-		method, ok := model[data.Method]
-		if !ok {
-			fmt.Printf("tcpmvc:%s:%s\n", StatusUnkonwMethod, data.Method)
-			continue
-		}
-		args := reflect.ValueOf(data.Args)
-		go method.Call([]reflect.Value{args})
-	}
-	return outErr
-}
-
-func (m *Mvc) read() (*Data, error) {
-	bytes, err := m.readTcpTag()
-	if err != nil {
-		return nil, err
-	}
-	var d Data
-	err = json.Unmarshal(bytes, &d)
-	if err != nil {
-		return nil, errors.New("JSON:" + StatusUnkonwTag + err.Error())
-	}
-	return &d, nil
-}
-
-//从TCP中读取本次数据
-func (m *Mvc) readTcpTag() ([]byte, error) {
-	if m.conn == nil {
-		return nil, errors.New(StatusTCPLose)
-	}
-	tag := make([]byte, N_TAG)
-	l, err := m.conn.Read(tag)
-	if err != nil {
-		if err == io.EOF {
-			return nil, errors.New(StatusReadOver)
-		}
-		return nil, errors.New(StatusReadError + err.Error())
-	}
-	if l != N_TAG {
-		return nil, errors.New("TAG:" + StatusUnkonwTag)
-	}
-	stag := string(tag)
-	switch stag {
-	case TAG:
-		_, err := m.conn.Read(tag)
-		if err != nil {
-			return nil, errors.New(StatusDataLengthLost)
-		}
-		lbody := binary.LittleEndian.Uint32(tag)
-		if lbody == 0 {
-			return nil, errors.New(StatusDataLengthZero)
-		}
-		raw := make([]byte, lbody)
-		lraw, err := m.conn.Read(raw)
-		if err != nil {
-			return nil, errors.New(StatusReadError + err.Error())
-		}
-		if uint32(lraw) != lbody {
-			return nil, errors.New(StatusDataLengthError)
-		}
-		return raw, nil
-	default:
-		return nil, errors.New("TAG:" + StatusUnkonwTag + stag)
-	}
-	return nil, errors.New("TAG2:" + StatusUnkonwTag + stag)
-}
-
-func (m *Mvc) Write(data *Data) error {
-	json, err := json.Marshal(data)
-	if err != nil {
-		return errors.New("josn fail:" + err.Error())
-	}
-	buff := bytes.NewBuffer([]byte{})
-	//头标签
-	binary.Write(buff, binary.LittleEndian, []byte(TAG))
-	err = binary.Write(buff, binary.LittleEndian, int32(len(json)))
-	if err != nil {
-		return errors.New("binary.Write:" + err.Error())
-	}
-	binary.Write(buff, binary.LittleEndian, json)
-	buffBytes := buff.Bytes()
-	l, err := m.conn.Write(buffBytes)
-	if err != nil {
-		return errors.New(StatusWriteFail + err.Error())
-	}
-	if l != len(buffBytes) {
-		return errors.New(StatusWriteLengthError)
-	}
-	fmt.Printf("成功写入：%s-%s\n", data.Model, data.Method)
-	return nil
-}
+package tcpmvc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	N_TAG      int    = 4      //标识长度
+	TAG        string = "mvc|" //标识字符串，用来判断是否是支持本方法读取解析
+	STREAM_TAG string = "stm|" //流数据帧标识，数据为 流id+标志位+流数据
+	LEGACY_TAG string = "tag|" //旧版本客户端的标识，数据为Data，数据长度比实际多1
+	ERROR_TAG  string = "err|" //错误帧标识，数据为RemoteError
+	BATCH_TAG  string = "bat|" //批量帧标识，数据为多个Data组成的JSON数组
+	RPC_TAG    string = "rpc|" //JSON-RPC 2.0帧标识，数据为一个请求、回复或批量请求
+)
+
+//错误说明，错误本身以ProtocolError返回，应使用errors.Is与ErrXXX比较，而不是比较字符串
+const (
+	StatusTCPLose          string = "TPC连接丢失;"
+	StatusReadError        string = "读取数据失败;"
+	StatusReadOver         string = "无更多数据可获取;"
+	StatusWriteFail        string = "数据写入失败"
+	StatusWriteLengthError string = "数据写入长度错误"
+	StatusUnkonwTag        string = "无法解析此数据1;"
+	StatusDataLengthLost   string = "无法解析数据大小;"
+	StatusDataLengthZero   string = "数据长度为0;"
+	StatusDataLengthError  string = "数据读取不完整;"
+	StatusUnkonwModel      string = "未知Model"
+	StatusUnkonwMethod     string = "未知Method"
+	StatusStreamClosed     string = "流已关闭;"
+	StatusStreamReset      string = "流被对端重置;"
+	StatusStreamUnknow     string = "未知的流;"
+	StatusStreamOverflow   string = "流数据超出接收窗口;"
+	StatusLegacyPeer       string = "旧版本客户端不支持流;"
+	StatusBadData          string = "无法解析Data;"
+	StatusEncodeFail       string = "无法编码Data;"
+	StatusDeadlineExceeded string = "已超过截止时间;"
+	StatusHandlerError     string = "处理方法返回错误;"
+	StatusRejected         string = "被拦截器拒绝;"
+	StatusJSONRPCPeer      string = "按行分隔的JSON-RPC连接不支持该帧;"
+)
+
+//一次Tcp数据
+type Data struct {
+	Model    string
+	Method   string
+	Args     map[string][]byte
+	Deadline int64 `json:",omitempty"` //截止时间，Unix毫秒，0表示不限；接收方在分发前丢弃已过期的Data，双方时钟需大致同步
+	//协议层的元数据，如追踪id、认证信息，与业务参数Args分开，供拦截器与框架读取，处理方法可以不关心
+	Meta map[string]string `json:",omitempty"`
+
+	mvc  *Mvc    //收到该Data的连接，发送的Data为nil
+	call replier //来自JSON-RPC请求、Call或Invoke时用于回复，通知与普通Data为nil
+}
+
+func NewData() *Data {
+	data := &Data{}
+	data.Args = make(map[string][]byte)
+	return data
+}
+
+//处理方法返回后调用，JSON-RPC请求据此回复结果，普通Data的处理方法返回错误时向对端发送错误帧
+func (d *Data) finish(result interface{}, err error) {
+	if d.call != nil {
+		d.call.reply(result, err)
+		return
+	}
+	if err != nil && d.mvc != nil {
+		code := CodeHandlerError
+		var perr *ProtocolError
+		if errors.As(err, &perr) {
+			code = perr.Code
+		}
+		d.mvc.writeError(code, err.Error(), d)
+	}
+}
+
+//设置一项元数据
+func (d *Data) SetMeta(key, value string) {
+	if d.Meta == nil {
+		d.Meta = make(map[string]string)
+	}
+	d.Meta[key] = value
+}
+
+//读取一项元数据，未设置时返回空字符串
+func (d *Data) GetMeta(key string) string {
+	return d.Meta[key]
+}
+
+//设置截止时间
+func (d *Data) SetDeadline(t time.Time) {
+	d.Deadline = t.UnixNano() / int64(time.Millisecond)
+}
+
+//设置从现在开始的有效时长
+func (d *Data) SetTimeout(ttl time.Duration) {
+	d.SetDeadline(time.Now().Add(ttl))
+}
+
+//截止时间，未设置时ok为false
+func (d *Data) DeadlineTime() (t time.Time, ok bool) {
+	if d.Deadline == 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, d.Deadline*int64(time.Millisecond)), true
+}
+
+//是否已超过截止时间
+func (d *Data) Expired() bool {
+	t, ok := d.DeadlineTime()
+	return ok && !time.Now().Before(t)
+}
+
+//带有截止时间的Context，处理方法可以用它限制耗时操作，例如转发的HTTP请求
+func (d *Data) Context() (context.Context, context.CancelFunc) {
+	t, ok := d.DeadlineTime()
+	if !ok {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithDeadline(context.Background(), t)
+}
+
+//帧的方向
+type Direction int
+
+const (
+	Inbound  Direction = iota //收到的帧
+	Outbound                  //发出的帧
+)
+
+//收发每一帧时调用，tag为帧标识，payload为帧数据，用于测试与抓包
+//payload在调用后仍可能被复用，需要保留时应复制
+type FrameHook func(dir Direction, tag string, payload []byte)
+
+type Mvc struct {
+	conn   net.Conn
+	Models map[string]map[string]reflect.Value
+	//LoseLink   chan int //断开了连接
+	Disconnect func()                 //连接断开、StartHandle返回前调用
+	Hook       FrameHook              //帧观察者，需在StartHandle之前设置
+	Resync     bool                   //遇到损坏的帧时跳过并继续读取，而不是断开连接，需在StartHandle之前设置
+	OnError    func(err *RemoteError) //收到对端的错误帧时调用，为nil时打印
+	//收到对端的第一帧、确定对端使用的协议（旧版本、JSON-RPC）后调用，主动发送的第一条消息应在此之后发送
+	Ready func()
+	//大于0时Write先等待这么长时间，把期间的多个Data合并为一帧发送，减少小消息的帧数与写调用
+	//对端在Meta中告知支持批量帧(CapBatch)之前不合并
+	BatchWindow time.Duration
+
+	invokers     map[string]map[string]invoker //Include时生成的方法调用
+	interceptors []Interceptor                 //分发前依次经过的拦截器
+	decoder      *Decoder
+	legacy       int32 //对端是旧版本客户端，回复时使用LEGACY_TAG
+	announced    int32 //已在发出的Data中告知本端支持的扩展
+	cmu          sync.RWMutex
+	peerCaps     []string //对端告知支持的扩展，受cmu保护
+	rpc          int32    //对端使用JSON-RPC，为rpcFramed或rpcLines
+
+	wmu        sync.Mutex         //写锁，保证每一帧完整写出，多个流可交替写
+	whdr       [frameScratch]byte //帧头，受wmu保护
+	wbuf       []byte             //拼接小帧的缓冲区，受wmu保护
+	smu        sync.Mutex         //保护streams
+	streams    map[uint64]*Stream //本端与对端打开的流
+	unclaimed  int                //对端打开、尚未被取走的流数量，受smu保护
+	nextStream uint32             //本端下一个流id
+	accept     chan *Stream       //对端打开、等待AcceptStream的流
+	streamErr  error              //连接断开后不能再打开流
+
+	bmu     sync.Mutex //保护pending
+	pending *batch     //等待合并发送的Data
+
+	calls  pendingCalls  //Call发出、等待回复的调用
+	closed chan struct{} //StartHandle返回时关闭
+}
+
+//c可以是任意net.Conn，例如*net.TCPConn或内存中的net.Pipe
+func New(c net.Conn) *Mvc {
+	models := make(map[string]map[string]reflect.Value)
+	m := new(Mvc)
+	m.conn = c
+	m.Models = models
+	m.invokers = make(map[string]map[string]invoker)
+	m.streams = make(map[uint64]*Stream)
+	m.accept = make(chan *Stream, acceptBacklog)
+	m.closed = make(chan struct{})
+	return m
+}
+
+//包含调用的struct的引用
+func (m *Mvc) Include(controller interface{}) {
+	fv := reflect.ValueOf(controller)
+	ct := reflect.Indirect(fv).Type()
+	modelName := ct.Name()
+	_, ok := m.Models[modelName]
+	if ok {
+		return
+	}
+	rt := fv.Type()
+	model := make(map[string]reflect.Value, rt.NumMethod())
+	invokers := make(map[string]invoker, rt.NumMethod())
+	for i := 0; i < rt.NumMethod(); i++ {
+		methodName := rt.Method(i).Name
+		model[methodName] = fv.MethodByName(methodName)
+		inv := directInvoker(fv, rt.Method(i))
+		if inv == nil {
+			inv = compileInvoker(model[methodName])
+		}
+		if inv != nil {
+			invokers[methodName] = inv
+		}
+	}
+	m.Models[modelName] = model
+	handled := m.invokers[modelName]
+	m.invokers[modelName] = invokers
+	//mvcgen生成的处理函数代替反射调用
+	if r, ok := controller.(Registrar); ok {
+		r.RegisterHandlers(m)
+	}
+	//保留Handle已注册的函数
+	for methodName, inv := range handled {
+		invokers[methodName] = inv
+	}
+}
+
+//读取并分发消息，直到连接断开
+//对端正常关闭连接时返回nil
+func (m *Mvc) StartHandle() error {
+	if m.conn == nil {
+		return ErrTCPLose
+	}
+	m.decoder = NewDecoder(m.conn)
+	m.decoder.Resync = m.Resync
+	var outErr error
+	ready := m.Ready
+	first := true
+	for {
+		tag, raw, err := m.decoder.Next()
+		if err != nil {
+			if err != io.EOF {
+				outErr = err
+			}
+			break
+		}
+		if m.Hook != nil {
+			m.Hook(Inbound, tag, raw)
+		}
+		//对端的协议只由第一帧决定，原生协议的对端偶尔发来的rpc|帧不改变本端的写法
+		if first {
+			m.detectRPC(tag)
+			first = false
+		}
+		err = m.handleFrame(tag, raw)
+		if err != nil {
+			if m.Resync {
+				m.decoder.Drop(N_TAG + 4 + len(raw))
+				fmt.Printf("tcpmvc:丢弃无法解析的帧:%s\n", err.Error())
+				continue
+			}
+			outErr = err
+			break
+		}
+		if ready != nil {
+			go ready()
+			ready = nil
+		}
+	}
+	m.closeStreams(outErr)
+	close(m.closed)
+	if m.Disconnect != nil {
+		m.Disconnect()
+	}
+	return outErr
+}
+
+//因数据损坏被丢弃的字节数，仅在开启Resync时统计
+func (m *Mvc) Dropped() uint64 {
+	if m.decoder == nil {
+		return 0
+	}
+	return m.decoder.Dropped()
+}
+
+//处理收到的一帧，返回错误时连接无法继续使用
+func (m *Mvc) handleFrame(tag string, raw []byte) error {
+	if tag == STREAM_TAG {
+		m.handleStream(raw)
+		return nil
+	}
+	if tag == ERROR_TAG {
+		return m.handleError(raw)
+	}
+	if tag == BATCH_TAG {
+		return m.handleBatch(raw)
+	}
+	if tag == RPC_TAG {
+		return m.handleRPC(raw)
+	}
+	if tag == LEGACY_TAG {
+		atomic.StoreInt32(&m.legacy, 1)
+	}
+	data, err := m.decode(raw)
+	if err != nil {
+		go m.writeError(CodeBadData, err.Error(), nil)
+		return err
+	}
+	m.dispatch(data)
+	return nil
+}
+
+//调用Data对应的控制器方法
+func (m *Mvc) dispatch(data *Data) {
+	m.readCaps(data)
+	if id := data.GetMeta(MetaReplyTo); id != "" {
+		m.deliver(id, data)
+		return
+	}
+	if id := data.GetMeta(MetaCallID); id != "" && data.call == nil {
+		data.call = &remoteCall{m: m, data: data}
+	}
+	model, ok := m.invokers[data.Model]
+	if !ok {
+		fmt.Printf("tcpmvc:%s:%s\n", StatusUnkonwModel, data.Model)
+		go m.writeError(CodeUnkonwModel, StatusUnkonwModel+data.Model, data)
+		return
+	}
+	//参数不匹配的方法没有invoker，同样视为未知Method
+	invoke, ok := model[data.Method]
+	if !ok {
+		fmt.Printf("tcpmvc:%s:%s\n", StatusUnkonwMethod, data.Method)
+		go m.writeError(CodeUnkonwMethod, StatusUnkonwMethod+data.Method, data)
+		return
+	}
+	//发送方已经不再等待结果
+	if data.Expired() {
+		fmt.Printf("tcpmvc:%s:%s.%s\n", StatusDeadlineExceeded, data.Model, data.Method)
+		go m.writeError(CodeDeadlineExceeded, StatusDeadlineExceeded, data)
+		return
+	}
+	data.mvc = m
+	go m.invoke(invoke, data)
+}
+
+//经过拦截器调用处理方法
+func (m *Mvc) invoke(invoke invoker, data *Data) {
+	m.intercept(invoke)(data)
+	//拦截器没有调用next时，JSON-RPC请求同样需要回复，已回复时不再重复
+	if data.call != nil {
+		data.call.reply(nil, newError(CodeRejected, "", nil))
+	}
+}
+
+func (m *Mvc) decode(bytes []byte) (*Data, error) {
+	var d Data
+	err := json.Unmarshal(bytes, &d)
+	if err != nil {
+		return nil, newError(CodeBadData, "JSON:", err)
+	}
+	return &d, nil
+}
+
+//发送Data，已超过截止时间的Data不再发送
+func (m *Mvc) Write(data *Data) error {
+	if data.Expired() {
+		return ErrDeadlineExceeded
+	}
+	if m.JSONRPC() {
+		return m.writeNotification(data)
+	}
+	if !m.Legacy() {
+		m.announceCaps(data)
+	}
+	buf, payload, err := encodeData(data)
+	if err != nil {
+		return err
+	}
+	defer putEncodeBuffer(buf)
+	if m.Legacy() {
+		return m.writeFrame(LEGACY_TAG, nil, payload)
+	}
+	//对端告知可以接收批量帧后才合并
+	if m.BatchWindow > 0 && m.PeerSupports(CapBatch) {
+		//批次在发送前持有数据，需要复制
+		return m.writeBatched(append([]byte(nil), payload...))
+	}
+	return m.writeFrame(TAG, nil, payload)
+}
+
+//对端是否是使用LEGACY_TAG的旧版本客户端，收到对端的第一帧后才能确定
+func (m *Mvc) Legacy() bool {
+	return atomic.LoadInt32(&m.legacy) == 1
+}
+
+//对端是否使用JSON-RPC，是时Write把Data作为JSON-RPC通知发送，收到对端的第一帧后才能确定
+func (m *Mvc) JSONRPC() bool {
+	return atomic.LoadInt32(&m.rpc) != 0
+}
+
+//以tag为标识写出一帧原始数据，用于重放等需要绕过Data编码的场景
+func (m *Mvc) WriteFrame(tag string, payload []byte) error {
+	if !isTag(tag) {
+		return newError(CodeUnkonwTag, tag, nil)
+	}
+	return m.writeFrame(tag, nil, payload)
+}
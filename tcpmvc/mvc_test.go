@@ -1,26 +1,267 @@
-package tcpmvc
-
-import (
-	"fmt"
-	"net"
-	"strconv"
-	"testing"
-)
-
-func Test(t *testing.T) {
-	tcp := &net.TCPConn{}
-	mvc := New(tcp)
-	a := &aa{}
-	a.File1 = 89
-	mvc.Include(a)
-	mvc.Models["aa"]["Ff"].Call(nil)
-	fmt.Println(mvc)
-}
-
-type aa struct {
-	File1 int
-}
-
-func (a *aa) Ff() {
-	fmt.Println("file1=" + strconv.Itoa(a.File1))
-}
+package tcpmvc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test(t *testing.T) {
+	tcp := &net.TCPConn{}
+	mvc := New(tcp)
+	a := &aa{}
+	a.File1 = 89
+	mvc.Include(a)
+	mvc.Models["aa"]["Ff"].Call(nil)
+	fmt.Println(mvc)
+}
+
+type aa struct {
+	File1 int
+}
+
+func (a *aa) Ff() {
+	fmt.Println("file1=" + strconv.Itoa(a.File1))
+}
+
+type invokeModel struct {
+	got chan string
+}
+
+func (c *invokeModel) Args(args map[string][]byte) {
+	c.got <- "args:" + string(args["msg"])
+}
+
+type namedArgs map[string][]byte
+
+func (c *invokeModel) Named(args namedArgs) {
+	c.got <- "named:" + string(args["msg"])
+}
+
+func (c *invokeModel) NoArgs() {
+	c.got <- "noargs"
+}
+
+func (c *invokeModel) WithData(data *Data) {
+	c.got <- "data:" + data.Method
+}
+
+func (c *invokeModel) ArgsErr(args map[string][]byte) error {
+	return errors.New("args:" + string(args["msg"]))
+}
+
+func (c *invokeModel) DataErr(data *Data) error {
+	return errors.New("data:" + data.Method)
+}
+
+func (c *invokeModel) Unsupported(n int) {
+	c.got <- "unsupported"
+}
+
+func TestInvokers(t *testing.T) {
+	m := New(&net.TCPConn{})
+	c := &invokeModel{got: make(chan string, 1)}
+	m.Include(c)
+	if _, ok := m.invokers["invokeModel"]["Unsupported"]; ok {
+		t.Fatal("method with unsupported arguments should not be dispatchable")
+	}
+	for method, want := range map[string]string{"Args": "args:hi", "Named": "named:hi", "NoArgs": "noargs", "WithData": "data:WithData"} {
+		data := NewData()
+		data.Model = "invokeModel"
+		data.Method = method
+		data.Args["msg"] = []byte("hi")
+		m.invokers["invokeModel"][method](data)
+		if got := <-c.got; got != want {
+			t.Fatalf("%s: got %q, want %q", method, got, want)
+		}
+	}
+
+	//直接调用的方法返回的错误同样回复给调用方
+	a, b := tcpPair(t)
+	b.Include(c)
+	for method, want := range map[string]string{"ArgsErr": "args:hi", "DataErr": "data:DataErr"} {
+		data := NewData()
+		data.Model = "invokeModel"
+		data.Method = method
+		data.Args["msg"] = []byte("hi")
+		ctx, cancel := data.Context()
+		_, err := a.Call(ctx, data)
+		cancel()
+		var rerr *RemoteError
+		if !errors.As(err, &rerr) || rerr.Message != want {
+			t.Fatalf("%s: got %v, want %q", method, err, want)
+		}
+	}
+
+	m.Handle("invokeModel", "Extra", func(args map[string][]byte) {
+		c.got <- "handle:" + string(args["msg"])
+	})
+	m.Include(c)
+	data := NewData()
+	data.Args["msg"] = []byte("hi")
+	m.invokers["invokeModel"]["Extra"](data)
+	if got := <-c.got; got != "handle:hi" {
+		t.Fatalf("got %q", got)
+	}
+}
+
+type registrarModel struct {
+	invokeModel
+}
+
+func (c *registrarModel) RegisterHandlers(m *Mvc) {
+	m.Handle("registrarModel", "Args", func(args map[string][]byte) {
+		c.got <- "generated:" + string(args["msg"])
+	})
+	m.HandleResult("registrarModel", "Sum", func(data *Data) (interface{}, error) {
+		return len(data.Args), nil
+	})
+}
+
+//Include调用RegisterHandlers代替反射，已用Handle注册的函数仍然优先
+func TestIncludeRegistrar(t *testing.T) {
+	m := New(&net.TCPConn{})
+	c := &registrarModel{invokeModel{got: make(chan string, 1)}}
+	m.Handle("registrarModel", "NoArgs", func(args map[string][]byte) {
+		c.got <- "handle"
+	})
+	m.Include(c)
+	if _, ok := m.invokers["registrarModel"]["RegisterHandlers"]; ok {
+		t.Fatal("RegisterHandlers should not be dispatchable")
+	}
+	for method, want := range map[string]string{"Args": "generated:hi", "NoArgs": "handle", "WithData": "data:WithData"} {
+		data := NewData()
+		data.Method = method
+		data.Args["msg"] = []byte("hi")
+		m.invokers["registrarModel"][method](data)
+		if got := <-c.got; got != want {
+			t.Fatalf("%s: got %q, want %q", method, got, want)
+		}
+	}
+
+	a, b := tcpPair(t)
+	b.Include(c)
+	data := NewData()
+	data.Model = "registrarModel"
+	data.Method = "Sum"
+	data.Args["x"] = []byte("1")
+	data.Args["y"] = []byte("2")
+	ctx, cancel := data.Context()
+	defer cancel()
+	result, err := a.Call(ctx, data)
+	if err != nil || string(result) != "2" {
+		t.Fatalf("got %s %v", result, err)
+	}
+}
+
+func TestDeadline(t *testing.T) {
+	data := NewData()
+	if data.Expired() {
+		t.Fatal("data without deadline should never expire")
+	}
+	data.SetTimeout(time.Hour)
+	ctx, cancel := data.Context()
+	defer cancel()
+	if d, ok := ctx.Deadline(); !ok || time.Until(d) < 59*time.Minute {
+		t.Fatalf("context deadline %v %v", d, ok)
+	}
+
+	a, b := tcpPair(t)
+	c := &capturer{got: make(chan string, 1)}
+	b.Include(c)
+	got := make(chan *RemoteError, 1)
+	a.OnError = func(err *RemoteError) {
+		got <- err
+	}
+	data.Model = "capturer"
+	data.Method = "Message"
+	data.SetDeadline(time.Now().Add(-time.Second))
+	if err := a.Write(data); !errors.Is(err, ErrDeadlineExceeded) {
+		t.Fatalf("write expired data: %v", err)
+	}
+	//模拟在传输途中过期
+	b.dispatch(data)
+	select {
+	case rerr := <-got:
+		if rerr.Code != CodeDeadlineExceeded || rerr.Method != "Message" {
+			t.Fatalf("got %+v", rerr)
+		}
+	case msg := <-c.got:
+		t.Fatalf("expired data was dispatched: %q", msg)
+	case <-time.After(time.Second):
+		t.Fatal("no error frame received")
+	}
+
+	data.SetTimeout(time.Minute)
+	data.Args["msg"] = []byte("ok")
+	if err := a.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-c.got:
+		if msg != "ok" {
+			t.Fatalf("got %q", msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("data before deadline was not dispatched")
+	}
+}
+
+func TestMetaInterceptors(t *testing.T) {
+	//未设置Meta时编码结果与旧版本相同
+	buf, payload, err := encodeData(NewData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(payload), "Meta") {
+		t.Fatalf("empty meta encoded: %s", payload)
+	}
+	putEncodeBuffer(buf)
+
+	a, b := tcpPair(t)
+	c := &capturer{got: make(chan string, 1)}
+	b.Include(c)
+	var order []string
+	b.Use(func(data *Data, next func(data *Data)) {
+		order = append(order, "trace:"+data.GetMeta("trace"))
+		next(data)
+	}, func(data *Data, next func(data *Data)) {
+		if data.GetMeta("token") != "secret" {
+			c.got <- "rejected"
+			return
+		}
+		next(data)
+	})
+
+	for _, token := range []string{"secret", "wrong"} {
+		order = nil
+		data := NewData()
+		data.Model = "capturer"
+		data.Method = "Message"
+		data.Args["msg"] = []byte("ok")
+		data.SetMeta("trace", "t1")
+		data.SetMeta("token", token)
+		if err := a.Write(data); err != nil {
+			t.Fatal(err)
+		}
+		want := "ok"
+		if token != "secret" {
+			want = "rejected"
+		}
+		select {
+		case msg := <-c.got:
+			if msg != want {
+				t.Fatalf("token %s: got %q", token, msg)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("data was not dispatched")
+		}
+		if len(order) != 1 || order[0] != "trace:t1" {
+			t.Fatalf("interceptor order %q", order)
+		}
+	}
+}
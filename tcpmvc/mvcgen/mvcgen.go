@@ -0,0 +1,430 @@
+//mvcgen 读取控制器类型的源码，生成服务端接口、代替反射注册处理函数的RegisterHandlers与调用该控制器的客户端代码，在控制器所在的包中由go generate运行
+//
+//	//go:generate go run ./tcpmvc/mvcgen -type tcpWorker -client client/proxy_mvc.go -client-type proxyClient
+//
+//服务端文件中的接口断言保证控制器仍然实现生成时的方法，重命名或删除方法后无法编译，需要重新生成；
+//重新生成的客户端代码随之改名，仍在调用旧方法的一方同样无法编译，而不是在运行时得到“未知Method”
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func main() {
+	typeName := flag.String("type", "", "控制器类型名，也是Include注册的Model名")
+	dir := flag.String("dir", ".", "控制器所在包的目录")
+	output := flag.String("o", "", "服务端文件路径，默认为目录下的<类型名小写>_mvc.go")
+	client := flag.String("client", "", "客户端文件路径，为空时不生成")
+	clientType := flag.String("client-type", "", "客户端类型名，默认为<类型名>Client")
+	clientPkg := flag.String("client-pkg", "", "客户端文件的包名，默认读取目标目录中其他文件的包名")
+	exclude := flag.String("exclude", "", "不生成的方法，以逗号分隔，例如只在本端调用的方法")
+	flag.Parse()
+	if *typeName == "" {
+		fmt.Fprintln(os.Stderr, "用法：mvcgen -type 控制器类型 [参数]")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	if *output == "" {
+		*output = filepath.Join(*dir, strings.ToLower(*typeName)+"_mvc.go")
+	}
+	if *clientType == "" {
+		*clientType = *typeName + "Client"
+	}
+	ctrl, err := parseController(*dir, *typeName, strings.Split(*exclude, ","))
+	if err != nil {
+		fail(err)
+	}
+	src, err := ctrl.server()
+	if err != nil {
+		fail(err)
+	}
+	if err := ioutil.WriteFile(*output, src, 0644); err != nil {
+		fail(err)
+	}
+	if *client == "" {
+		return
+	}
+	if *clientPkg == "" {
+		*clientPkg, err = packageName(filepath.Dir(*client), *client)
+		if err != nil {
+			fail(err)
+		}
+	}
+	src, err = ctrl.client(*clientPkg, *clientType)
+	if err != nil {
+		fail(err)
+	}
+	if err := ioutil.WriteFile(*client, src, 0644); err != nil {
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "mvcgen:"+err.Error())
+	os.Exit(1)
+}
+
+//参数的形式，与tcpmvc.compileInvoker支持的参数一致
+type paramKind int
+
+const (
+	paramNone paramKind = iota
+	paramArgs           //map[string][]byte或以其为底层类型的命名类型
+	paramData           //*tcpmvc.Data
+)
+
+//可由消息调用的方法
+type method struct {
+	Name    string
+	Params  string //服务端接口中的参数列表
+	Results string //服务端接口中的返回值列表
+	Kind    paramKind
+	Result  string //客户端的返回值类型，没有返回值时为空，不能在客户端使用的类型为json.RawMessage
+	Error   bool   //是否返回error
+}
+
+type controller struct {
+	Pkg     string
+	Type    string
+	Methods []method
+	imports map[string]string //控制器源码中的导入，包名到路径
+	uses    map[string]bool   //服务端接口用到的包名
+}
+
+//解析目录中的Go文件，找出类型typeName可由消息调用的导出方法
+func parseController(dir, typeName string, exclude []string) (*controller, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	skip := make(map[string]bool)
+	for _, name := range exclude {
+		skip[strings.TrimSpace(name)] = true
+	}
+	for _, pkg := range pkgs {
+		c := &controller{Pkg: pkg.Name, Type: typeName, imports: make(map[string]string), uses: make(map[string]bool)}
+		argsTypes := make(map[string]bool) //以map[string][]byte为底层类型的命名类型
+		found := false
+		for _, f := range pkg.Files {
+			for _, imp := range f.Imports {
+				path := strings.Trim(imp.Path.Value, `"`)
+				name := path[strings.LastIndex(path, "/")+1:]
+				if imp.Name != nil {
+					name = imp.Name.Name
+				}
+				c.imports[name] = path
+			}
+			for _, decl := range f.Decls {
+				gen, ok := decl.(*ast.GenDecl)
+				if !ok || gen.Tok != token.TYPE {
+					continue
+				}
+				for _, spec := range gen.Specs {
+					ts := spec.(*ast.TypeSpec)
+					if ts.Name.Name == typeName {
+						found = true
+					}
+					if types.ExprString(ts.Type) == "map[string][]byte" {
+						argsTypes[ts.Name.Name] = true
+					}
+				}
+			}
+		}
+		if !found {
+			continue
+		}
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Recv == nil || !fn.Name.IsExported() || skip[fn.Name.Name] || receiverName(fn) != typeName {
+					continue
+				}
+				m, ok := c.method(fn, argsTypes)
+				if ok {
+					c.Methods = append(c.Methods, m)
+				}
+			}
+		}
+		sort.Slice(c.Methods, func(i, j int) bool {
+			return c.Methods[i].Name < c.Methods[j].Name
+		})
+		return c, nil
+	}
+	return nil, fmt.Errorf("%s中没有找到类型%s", dir, typeName)
+}
+
+func receiverName(fn *ast.FuncDecl) string {
+	t := fn.Recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	if id, ok := t.(*ast.Ident); ok {
+		return id.Name
+	}
+	return ""
+}
+
+//按tcpmvc分发时的规则检查方法的参数与返回值，不能由消息调用的方法返回false
+func (c *controller) method(fn *ast.FuncDecl, argsTypes map[string]bool) (method, bool) {
+	m := method{Name: fn.Name.Name}
+	var params []string
+	if fn.Type.Params != nil {
+		for _, field := range fn.Type.Params.List {
+			for range namesOrOne(field) {
+				params = append(params, types.ExprString(field.Type))
+			}
+		}
+	}
+	switch {
+	case len(params) == 0:
+		m.Kind = paramNone
+	case len(params) == 1 && (params[0] == "map[string][]byte" || argsTypes[params[0]]):
+		m.Kind = paramArgs
+	case len(params) == 1 && params[0] == "*"+c.tcpmvcName()+".Data":
+		m.Kind = paramData
+	default:
+		return m, false
+	}
+	var results []string
+	if fn.Type.Results != nil {
+		for _, field := range fn.Type.Results.List {
+			for range namesOrOne(field) {
+				results = append(results, types.ExprString(field.Type))
+			}
+		}
+	}
+	switch {
+	case len(results) == 0:
+	case len(results) == 1 && results[0] == "error":
+		m.Error = true
+	case len(results) == 1:
+		m.Result = results[0]
+	case len(results) == 2 && results[1] == "error":
+		m.Result = results[0]
+		m.Error = true
+	default:
+		return m, false
+	}
+	m.Params = strings.Join(params, ", ")
+	m.Results = strings.Join(results, ", ")
+	if len(results) > 1 {
+		m.Results = "(" + m.Results + ")"
+	}
+	for _, t := range append(params, results...) {
+		c.use(t)
+	}
+	if m.Result != "" && !portable(m.Result) {
+		m.Result = "json.RawMessage"
+	}
+	return m, true
+}
+
+func namesOrOne(field *ast.Field) []*ast.Ident {
+	if len(field.Names) == 0 {
+		return []*ast.Ident{nil}
+	}
+	return field.Names
+}
+
+//源码中tcpmvc包的名称
+func (c *controller) tcpmvcName() string {
+	for name, path := range c.imports {
+		if strings.HasSuffix(path, "/tcpmvc") {
+			return name
+		}
+	}
+	return "tcpmvc"
+}
+
+//记录类型表达式中用到的包
+func (c *controller) use(typ string) {
+	expr, err := parser.ParseExpr(typ)
+	if err != nil {
+		return
+	}
+	ast.Inspect(expr, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok {
+			if id, ok := sel.X.(*ast.Ident); ok {
+				c.uses[id.Name] = true
+			}
+		}
+		return true
+	})
+}
+
+//类型只由预声明的类型组成，可以直接在客户端的包中使用
+func portable(typ string) bool {
+	expr, err := parser.ParseExpr(typ)
+	if err != nil {
+		return false
+	}
+	ok := true
+	ast.Inspect(expr, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.SelectorExpr, *ast.StructType, *ast.InterfaceType, *ast.FuncType, *ast.ChanType:
+			ok = false
+		case *ast.Ident:
+			if types.Universe.Lookup(n.Name) == nil {
+				ok = false
+			}
+		}
+		return ok
+	})
+	return ok
+}
+
+//读取目录中其他Go文件的包名
+func packageName(dir, except string) (string, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go") && fi.Name() != filepath.Base(except)
+	}, parser.PackageClauseOnly)
+	if err != nil {
+		return "", err
+	}
+	for name := range pkgs {
+		return name, nil
+	}
+	return "", fmt.Errorf("无法确定%s的包名，请使用-client-pkg", dir)
+}
+
+const header = "// Code generated by mvcgen. DO NOT EDIT.\n\n"
+
+//服务端文件：控制器可由消息调用的方法组成的接口，控制器实现该接口的断言，
+//以及Include时代替反射直接注册各方法的RegisterHandlers
+func (c *controller) server() ([]byte, error) {
+	pkg := c.tcpmvcName()
+	if _, ok := c.imports[pkg]; !ok {
+		c.imports[pkg] = "pointTest/tcpProxy/tcpmvc"
+	}
+	c.uses[pkg] = true
+	var b bytes.Buffer
+	b.WriteString(header)
+	fmt.Fprintf(&b, "package %s\n\n", c.Pkg)
+	var imports []string
+	for name := range c.uses {
+		path, ok := c.imports[name]
+		if !ok {
+			continue
+		}
+		if strings.HasSuffix(path, "/"+name) || path == name {
+			imports = append(imports, fmt.Sprintf("%q", path))
+		} else {
+			imports = append(imports, fmt.Sprintf("%s %q", name, path))
+		}
+	}
+	if len(imports) > 0 {
+		sort.Strings(imports)
+		fmt.Fprintf(&b, "import (\n%s\n)\n\n", strings.Join(imports, "\n"))
+	}
+	iface := c.Type + "Server"
+	fmt.Fprintf(&b, "//%s可由消息调用的方法，方法改名或删除后下面的断言无法编译，需重新运行go generate\n", c.Type)
+	fmt.Fprintf(&b, "type %s interface {\n", iface)
+	for _, m := range c.Methods {
+		fmt.Fprintf(&b, "%s(%s) %s\n", m.Name, m.Params, m.Results)
+	}
+	b.WriteString("}\n\n")
+	fmt.Fprintf(&b, "var _ %s = (*%s)(nil)\n\n", iface, c.Type)
+	fmt.Fprintf(&b, "//直接注册%s可由消息调用的方法，不经过反射，Include时自动调用\n", c.Type)
+	fmt.Fprintf(&b, "func (impl *%s) RegisterHandlers(m *%s.Mvc) {\n", c.Type, pkg)
+	for _, m := range c.Methods {
+		var call string
+		switch {
+		case m.Kind == paramNone:
+			call = "impl." + m.Name + "()"
+		case m.Kind == paramData:
+			call = "impl." + m.Name + "(data)"
+		case m.Params == "map[string][]byte":
+			call = "impl." + m.Name + "(data.Args)"
+		default:
+			call = "impl." + m.Name + "(" + m.Params + "(data.Args))"
+		}
+		switch {
+		case m.Results == "" && m.Kind == paramArgs && m.Params == "map[string][]byte":
+			fmt.Fprintf(&b, "m.Handle(%q, %q, impl.%s)\n", c.Type, m.Name, m.Name)
+		case m.Results == "" && m.Kind == paramData:
+			fmt.Fprintf(&b, "m.HandleData(%q, %q, impl.%s)\n", c.Type, m.Name, m.Name)
+		case m.Results == "":
+			fmt.Fprintf(&b, "m.HandleData(%q, %q, func(data *%s.Data) {\n%s\n})\n", c.Type, m.Name, pkg, call)
+		default:
+			fmt.Fprintf(&b, "m.HandleResult(%q, %q, func(data *%s.Data) (interface{}, error) {\n", c.Type, m.Name, pkg)
+			switch {
+			case m.Result == "":
+				fmt.Fprintf(&b, "return nil, %s\n", call)
+			case !m.Error:
+				fmt.Fprintf(&b, "return %s, nil\n", call)
+			default:
+				fmt.Fprintf(&b, "return %s\n", call)
+			}
+			b.WriteString("})\n")
+		}
+	}
+	b.WriteString("}\n")
+	return format.Source(b.Bytes())
+}
+
+//客户端文件：向对端的控制器发送消息或调用其方法
+func (c *controller) client(pkg, typ string) ([]byte, error) {
+	needJSON, needCtx := false, false
+	for _, m := range c.Methods {
+		if m.Result != "" {
+			needJSON = true
+		}
+		if m.Result != "" || m.Error {
+			needCtx = true
+		}
+	}
+	var b bytes.Buffer
+	b.WriteString(header)
+	fmt.Fprintf(&b, "package %s\n\nimport (\n", pkg)
+	if needCtx {
+		b.WriteString("\"context\"\n")
+	}
+	if needJSON {
+		b.WriteString("\"encoding/json\"\n")
+	}
+	b.WriteString("\"pointTest/tcpProxy/tcpmvc\"\n)\n\n")
+	fmt.Fprintf(&b, "//调用对端%s的方法，由mvcgen根据%s包中的%s生成\n", c.Type, c.Pkg, c.Type)
+	fmt.Fprintf(&b, "type %s struct {\nMvc *tcpmvc.Mvc\n}\n\n", typ)
+	for _, m := range c.Methods {
+		fmt.Fprintf(&b, "//创建发往%s.%s的Data，需要设置截止时间或Meta时使用\n", c.Type, m.Name)
+		fmt.Fprintf(&b, "func (c %s) New%s(args map[string][]byte) *tcpmvc.Data {\n", typ, m.Name)
+		fmt.Fprintf(&b, "data := tcpmvc.NewData()\ndata.Model = %q\ndata.Method = %q\n", c.Type, m.Name)
+		b.WriteString("if args != nil {\ndata.Args = args\n}\nreturn data\n}\n\n")
+		fmt.Fprintf(&b, "//发送%s.%s，不等待处理结果\n", c.Type, m.Name)
+		fmt.Fprintf(&b, "func (c %s) %s(args map[string][]byte) error {\nreturn c.Mvc.Write(c.New%s(args))\n}\n\n", typ, m.Name, m.Name)
+		if m.Result == "" && !m.Error {
+			continue
+		}
+		fmt.Fprintf(&b, "//调用%s.%s并等待返回值\n", c.Type, m.Name)
+		if m.Result == "" {
+			fmt.Fprintf(&b, "func (c %s) Call%s(ctx context.Context, args map[string][]byte) error {\n", typ, m.Name)
+			fmt.Fprintf(&b, "_, err := c.Mvc.Call(ctx, c.New%s(args))\nreturn err\n}\n\n", m.Name)
+			continue
+		}
+		fmt.Fprintf(&b, "func (c %s) Call%s(ctx context.Context, args map[string][]byte) (%s, error) {\n", typ, m.Name, m.Result)
+		fmt.Fprintf(&b, "var result %s\nraw, err := c.Mvc.Call(ctx, c.New%s(args))\n", m.Result, m.Name)
+		b.WriteString("if err != nil {\nreturn result, err\n}\n")
+		if m.Result == "json.RawMessage" {
+			b.WriteString("return raw, nil\n}\n\n")
+		} else {
+			b.WriteString("err = json.Unmarshal(raw, &result)\nreturn result, err\n}\n\n")
+		}
+	}
+	return format.Source(b.Bytes())
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const controllerSrc = `package demo
+
+import mvc "pointTest/tcpProxy/tcpmvc"
+
+type params map[string][]byte
+
+type result struct{ N int }
+
+type Demo struct{}
+
+func (d *Demo) Notify(args map[string][]byte)             {}
+func (d *Demo) Named(args params) error                    { return nil }
+func (d *Demo) WithData(data *mvc.Data) (int, error)       { return 0, nil }
+func (d Demo) Struct() result                              { return result{} }
+func (d *Demo) Skipped(args map[string][]byte)             {}
+func (d *Demo) TwoArgs(a, b map[string][]byte)             {}
+func (d *Demo) ThreeResults() (int, int, error)            { return 0, 0, nil }
+func (d *Demo) unexported(args map[string][]byte)          {}
+func (o *Other) Notify(args map[string][]byte)             {}
+
+type Other struct{}
+`
+
+func TestGenerate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mvcgen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "demo.go"), []byte(controllerSrc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := parseController(dir, "Demo", []string{"Skipped"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, m := range c.Methods {
+		names = append(names, m.Name)
+	}
+	if strings.Join(names, ",") != "Named,Notify,Struct,WithData" {
+		t.Fatalf("methods %v", names)
+	}
+
+	server, err := c.server()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`mvc "pointTest/tcpProxy/tcpmvc"`,
+		"WithData(*mvc.Data) (int, error)",
+		"Named(params) error",
+		"var _ DemoServer = (*Demo)(nil)",
+		"func (impl *Demo) RegisterHandlers(m *mvc.Mvc)",
+		`m.Handle("Demo", "Notify", impl.Notify)`,
+		"return nil, impl.Named(params(data.Args))",
+		"return impl.WithData(data)",
+		"return impl.Struct(), nil",
+	} {
+		if !strings.Contains(string(server), want) {
+			t.Fatalf("server file missing %q:\n%s", want, server)
+		}
+	}
+
+	client, err := c.client("caller", "demoClient")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"package caller",
+		"func (c demoClient) Notify(args map[string][]byte) error",
+		"func (c demoClient) CallNamed(ctx context.Context, args map[string][]byte) error",
+		"func (c demoClient) CallWithData(ctx context.Context, args map[string][]byte) (int, error)",
+		"func (c demoClient) CallStruct(ctx context.Context, args map[string][]byte) (json.RawMessage, error)",
+	} {
+		if !strings.Contains(string(client), want) {
+			t.Fatalf("client file missing %q:\n%s", want, client)
+		}
+	}
+	if strings.Contains(string(client), "CallNotify") {
+		t.Fatal("methods without results should not have Call stubs")
+	}
+}
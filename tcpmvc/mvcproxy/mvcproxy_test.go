@@ -0,0 +1,168 @@
+package mvcproxy
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"testing"
+)
+
+//在随机端口上运行serve，返回监听地址
+func listen(t *testing.T, serve func(c net.Conn)) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(c)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func echo(c net.Conn) {
+	defer c.Close()
+	io.Copy(c, c)
+}
+
+func pipe(a, b net.Conn) {
+	go io.Copy(a, b)
+	io.Copy(b, a)
+	a.Close()
+	b.Close()
+}
+
+//只接受user:pass的HTTP CONNECT代理
+func connectProxy(c net.Conn) {
+	br := bufio.NewReader(c)
+	req, err := http.ReadRequest(br)
+	if err != nil || req.Method != http.MethodConnect {
+		c.Close()
+		return
+	}
+	if req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte("user:pass")) {
+		io.WriteString(c, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+		c.Close()
+		return
+	}
+	target, err := net.Dial("tcp", req.Host)
+	if err != nil {
+		io.WriteString(c, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+		c.Close()
+		return
+	}
+	io.WriteString(c, "HTTP/1.1 200 Connection established\r\n\r\n")
+	pipe(c, target)
+}
+
+//只接受user:pass的SOCKS5代理，只支持域名与IPv4地址
+func socksProxy(c net.Conn) {
+	fail := func() { c.Close() }
+	buf := make([]byte, 512)
+	if _, err := io.ReadFull(c, buf[:2]); err != nil {
+		fail()
+		return
+	}
+	if _, err := io.ReadFull(c, buf[:buf[1]]); err != nil {
+		fail()
+		return
+	}
+	c.Write([]byte{5, socksUserPass})
+	io.ReadFull(c, buf[:2])
+	user := make([]byte, buf[1])
+	io.ReadFull(c, user)
+	io.ReadFull(c, buf[:1])
+	password := make([]byte, buf[0])
+	io.ReadFull(c, password)
+	if string(user) != "user" || string(password) != "pass" {
+		c.Write([]byte{1, 1})
+		fail()
+		return
+	}
+	c.Write([]byte{1, 0})
+
+	io.ReadFull(c, buf[:4])
+	var host string
+	switch buf[3] {
+	case socksIPv4:
+		io.ReadFull(c, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case socksDomain:
+		io.ReadFull(c, buf[:1])
+		name := make([]byte, buf[0])
+		io.ReadFull(c, name)
+		host = string(name)
+	}
+	io.ReadFull(c, buf[:2])
+	port := int(buf[0])<<8 | int(buf[1])
+	target, err := net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		c.Write([]byte{5, 5, 0, socksIPv4, 0, 0, 0, 0, 0, 0})
+		fail()
+		return
+	}
+	c.Write([]byte{5, 0, 0, socksIPv4, 127, 0, 0, 1, 0, 0})
+	pipe(c, target)
+}
+
+func TestDial(t *testing.T) {
+	target := listen(t, echo)
+	_, port, _ := net.SplitHostPort(target)
+	httpProxy := listen(t, connectProxy)
+	socks := listen(t, socksProxy)
+
+	for _, c := range []struct {
+		proxy  string
+		target string
+		err    error
+	}{
+		{"http://user:pass@" + httpProxy, target, nil},
+		{"http://user:wrong@" + httpProxy, target, ErrProxyAuth},
+		{"socks5://user:pass@" + socks, target, nil},
+		{"socks5h://user:pass@" + socks, "localhost:" + port, nil},
+		{"socks5://user:wrong@" + socks, target, ErrProxyAuth},
+		{"socks5://" + socks, target, ErrProxyAuth},
+	} {
+		d, err := New(c.proxy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn, err := d.Dial("tcp", c.target)
+		if c.err != nil {
+			if !errors.Is(err, c.err) {
+				t.Fatalf("%s: want %v, got %v", c.proxy, c.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", c.proxy, err)
+		}
+		conn.Write([]byte("ping"))
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != "ping" {
+			t.Fatalf("%s: echo %q %v", c.proxy, buf, err)
+		}
+		conn.Close()
+	}
+
+	if _, err := New("ftp://" + httpProxy); !errors.Is(err, ErrUnsupported) {
+		t.Fatalf("ftp proxy: %v", err)
+	}
+	//nil Dialer直接连接
+	var d *Dialer
+	conn, err := d.Dial("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
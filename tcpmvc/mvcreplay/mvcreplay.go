@@ -0,0 +1,77 @@
+//mvcreplay 把tcpmvc抓包文件中的帧重新发送到一个正在运行的代理或后端，用于在本地复现问题
+//
+//	mvcreplay -addr 127.0.0.1:7000 -dir out -conn 127.0.0.1:52311 capture.log
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"pointTest/tcpProxy/tcpmvc"
+	"time"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:7000", "要连接的代理或后端地址")
+	dir := flag.String("dir", "out", "重放哪个方向的帧：out为抓包端发出的帧，in为抓包端收到的帧")
+	conn := flag.String("conn", "", "只重放该连接的帧，为空时重放全部")
+	realtime := flag.Bool("realtime", true, "按记录的时间间隔发送")
+	wait := flag.Duration("wait", 2*time.Second, "发送完毕后等待回复的时间")
+	//抓包文件不记录JSON-RPC连接的分帧方式，按行分隔的连接需要指定
+	lines := flag.Bool("lines", false, "JSON-RPC帧按行发送，重放按行分隔的JSON-RPC连接")
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "用法：mvcreplay [参数] 抓包文件")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+	f, err := os.Open(flag.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "打开抓包文件失败：%s\n", err.Error())
+		os.Exit(1)
+	}
+	defer f.Close()
+
+	c, err := net.Dial("tcp", *addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "连接%s失败：%s\n", *addr, err.Error())
+		os.Exit(1)
+	}
+	defer c.Close()
+	mvc := tcpmvc.New(c)
+	mvc.Hook = func(d tcpmvc.Direction, tag string, payload []byte) {
+		fmt.Printf("%s %s %s %s\n", time.Now().Format("15:04:05.000"), d, tag, payload)
+	}
+	go mvc.StartHandle()
+
+	var last time.Time
+	count := 0
+	err = tcpmvc.ReadCapture(f, func(rec *tcpmvc.CaptureRecord) error {
+		if rec.Dir != *dir || (*conn != "" && rec.Conn != *conn) {
+			return nil
+		}
+		if *realtime && !last.IsZero() && rec.Time.After(last) {
+			time.Sleep(rec.Time.Sub(last))
+		}
+		last = rec.Time
+		count++
+		if *lines {
+			if rec.Tag != tcpmvc.RPC_TAG {
+				return fmt.Errorf("按行分隔的JSON-RPC连接不能发送%s帧", rec.Tag)
+			}
+			if _, err := c.Write(append(rec.Payload, '\n')); err != nil {
+				return err
+			}
+			mvc.Hook(tcpmvc.Outbound, rec.Tag, rec.Payload)
+			return nil
+		}
+		return mvc.WriteFrame(rec.Tag, rec.Payload)
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "重放失败：%s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Printf("已重放%d帧\n", count)
+	time.Sleep(*wait)
+}
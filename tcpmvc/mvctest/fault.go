@@ -0,0 +1,163 @@
+package mvctest
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//注入的断开连接
+var ErrInjected = errors.New("mvctest: 注入的网络故障")
+
+//注入的网络故障，零值不注入任何故障
+type Faults struct {
+	Latency time.Duration //每次Read、Write前的延迟
+	Jitter  time.Duration //在Latency之外随机增加[0,Jitter)的延迟
+
+	ReadChunk  int           //每次Read随机返回1到ReadChunk字节，模拟分片与部分读取，0不限
+	WriteChunk int           //每次Write随机拆成1到WriteChunk字节的多次写入，0不拆分
+	SlowWrite  time.Duration //拆分后每次写入之间的延迟，模拟慢速的写入方
+
+	CorruptRate float64 //读到的每个字节被改写的概率
+	DropAfter   int64   //读写共计超过该字节数后断开连接，0不断开
+
+	Seed int64 //随机数种子，相同的种子与读写顺序得到相同的故障
+}
+
+//按Faults注入故障的net.Conn，包装一个真实连接
+//可用NewPairConn放在一对Mvc之间，或在代理与客户端中包装TCP连接
+type FaultConn struct {
+	net.Conn
+	faults Faults
+
+	rmu  sync.Mutex //rand.Rand不能并发使用
+	rand *rand.Rand
+
+	bytes   int64 //已读写的字节数
+	dropped int32
+}
+
+func NewFaultConn(c net.Conn, f Faults) *FaultConn {
+	return &FaultConn{Conn: c, faults: f, rand: rand.New(rand.NewSource(f.Seed))}
+}
+
+func (c *FaultConn) intn(n int) int {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	return c.rand.Intn(n)
+}
+
+func (c *FaultConn) float() float64 {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	return c.rand.Float64()
+}
+
+func (c *FaultConn) delay() {
+	d := c.faults.Latency
+	if c.faults.Jitter > 0 {
+		d += time.Duration(c.intn(int(c.faults.Jitter)))
+	}
+	if d > 0 {
+		time.Sleep(d)
+	}
+}
+
+//累计读写的字节数，超过DropAfter时断开连接
+func (c *FaultConn) count(n int) error {
+	total := atomic.AddInt64(&c.bytes, int64(n))
+	if c.faults.DropAfter > 0 && total > c.faults.DropAfter {
+		c.Drop()
+	}
+	if atomic.LoadInt32(&c.dropped) != 0 {
+		return ErrInjected
+	}
+	return nil
+}
+
+func (c *FaultConn) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&c.dropped) != 0 {
+		return 0, ErrInjected
+	}
+	c.delay()
+	if c.faults.ReadChunk > 0 && len(p) > 1 {
+		max := c.faults.ReadChunk
+		if max > len(p) {
+			max = len(p)
+		}
+		p = p[:1+c.intn(max)]
+	}
+	n, err := c.Conn.Read(p)
+	if c.faults.CorruptRate > 0 {
+		for i := 0; i < n; i++ {
+			if c.float() < c.faults.CorruptRate {
+				p[i] ^= byte(1 + c.intn(255))
+			}
+		}
+	}
+	if cerr := c.count(n); cerr != nil && err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+func (c *FaultConn) Write(p []byte) (int, error) {
+	written := 0
+	for written < len(p) {
+		if atomic.LoadInt32(&c.dropped) != 0 {
+			return written, ErrInjected
+		}
+		if written == 0 {
+			c.delay()
+		} else if c.faults.SlowWrite > 0 {
+			time.Sleep(c.faults.SlowWrite)
+		}
+		chunk := p[written:]
+		if c.faults.WriteChunk > 0 && len(chunk) > 1 {
+			max := c.faults.WriteChunk
+			if max > len(chunk) {
+				max = len(chunk)
+			}
+			chunk = chunk[:1+c.intn(max)]
+		}
+		n, err := c.Conn.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		if err := c.count(n); err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+//立即断开连接，之后的读写都返回ErrInjected
+func (c *FaultConn) Drop() {
+	if atomic.CompareAndSwapInt32(&c.dropped, 0, 1) {
+		c.Conn.Close()
+	}
+}
+
+//已读写的字节数
+func (c *FaultConn) Bytes() int64 {
+	return atomic.LoadInt64(&c.bytes)
+}
+
+//与NewPair相同，但A端的连接按faults注入故障，返回的FaultConn可用于手动断开
+func NewFaultPair(t testing.TB, faults Faults, controllers ...interface{}) (*Pair, *FaultConn) {
+	connA, connB := net.Pipe()
+	fc := NewFaultConn(connA, faults)
+	p := NewPairConn(fc, connB)
+	for _, c := range controllers {
+		p.A.Include(c)
+		p.B.Include(c)
+	}
+	p.Start()
+	t.Cleanup(p.Close)
+	return p, fc
+}
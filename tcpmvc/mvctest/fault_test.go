@@ -0,0 +1,146 @@
+package mvctest
+
+import (
+	"context"
+	"errors"
+	"net"
+	"pointTest/tcpProxy/tcpmvc"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type adder struct{}
+
+func (a *adder) Add(args map[string][]byte) (int, error) {
+	x, err := strconv.Atoi(string(args["a"]))
+	if err != nil {
+		return 0, err
+	}
+	y, err := strconv.Atoi(string(args["b"]))
+	return x + y, err
+}
+
+func add(m *tcpmvc.Mvc, a, b int) (string, error) {
+	return addWithin(m, DefaultTimeout, a, b)
+}
+
+func addWithin(m *tcpmvc.Mvc, timeout time.Duration, a, b int) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	data := tcpmvc.NewData()
+	data.Model = "adder"
+	data.Method = "Add"
+	data.Args["a"] = []byte(strconv.Itoa(a))
+	data.Args["b"] = []byte(strconv.Itoa(b))
+	result, err := m.Call(ctx, data)
+	return string(result), err
+}
+
+//帧被拆成很小的片段、读写有延迟时，并发的调用仍得到正确的结果
+func TestFaultChunking(t *testing.T) {
+	p, fc := NewFaultPair(t, Faults{
+		Latency:    50 * time.Microsecond,
+		Jitter:     100 * time.Microsecond,
+		ReadChunk:  3,
+		WriteChunk: 5,
+		SlowWrite:  10 * time.Microsecond,
+		Seed:       1,
+	}, &adder{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			from := p.A
+			if i%2 == 1 {
+				from = p.B
+			}
+			got, err := add(from, i, 1000)
+			if err != nil || got != strconv.Itoa(i+1000) {
+				t.Errorf("%d: got %s %v", i, got, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if fc.Bytes() == 0 {
+		t.Fatal("no bytes counted")
+	}
+}
+
+//连接在传输中途断开时，等待中的调用返回错误而不是一直阻塞
+func TestFaultDrop(t *testing.T) {
+	p, fc := NewFaultPair(t, Faults{DropAfter: 10}, &adder{})
+	_, err := add(p.A, 1, 2)
+	if !errors.Is(err, tcpmvc.ErrTCPLose) && !errors.Is(err, ErrInjected) {
+		t.Fatalf("got %v", err)
+	}
+	if err := p.A.Write(tcpmvc.NewData()); err == nil {
+		t.Fatal("write after drop")
+	}
+	fc.Drop()
+}
+
+//收到损坏的字节时StartHandle返回解析错误而不是panic，关闭连接后等待回复的调用返回ErrTCPLose
+//调用依次发出，同一个Seed每次得到相同的故障
+func TestFaultCorrupt(t *testing.T) {
+	p, _ := NewFaultPair(t, Faults{CorruptRate: 0.05, Seed: 2}, &adder{})
+	lost := make(chan error, 1)
+	go func() {
+		for i := 0; ; i++ {
+			if _, err := add(p.B, i, i); err != nil {
+				var rerr *tcpmvc.RemoteError
+				if !errors.As(err, &rerr) {
+					lost <- err
+					return
+				}
+			}
+		}
+	}()
+
+	ended, err := p.Wait(p.A, DefaultTimeout)
+	if !ended {
+		t.Fatal("corrupted frames did not stop StartHandle")
+	}
+	if err == nil || errors.Is(err, tcpmvc.ErrReadError) {
+		t.Fatalf("StartHandle returned %v, want a decode error", err)
+	}
+	p.Close()
+	select {
+	case err := <-lost:
+		if !errors.Is(err, tcpmvc.ErrTCPLose) {
+			t.Fatalf("pending call got %v", err)
+		}
+	case <-time.After(DefaultTimeout):
+		t.Fatal("pending call not failed after close")
+	}
+}
+
+//开启Resync后丢弃损坏的帧并继续处理，之后完好的调用仍得到正确的结果
+func TestFaultCorruptResync(t *testing.T) {
+	connA, connB := net.Pipe()
+	p := NewPairConn(NewFaultConn(connA, Faults{CorruptRate: 0.002, Seed: 3}), connB)
+	p.A.Include(&adder{})
+	p.A.Resync = true
+	p.Start()
+	defer p.Close()
+
+	ok := 0
+	for i := 0; i < 100; i++ {
+		got, err := addWithin(p.B, 50*time.Millisecond, i, i)
+		if err == nil && got != strconv.Itoa(2*i) {
+			t.Fatalf("%d: got %s", i, got)
+		}
+		if err == nil {
+			ok++
+		}
+	}
+	if ended, err := p.Wait(p.A, 0); ended {
+		t.Fatalf("StartHandle returned %v with Resync", err)
+	}
+	if p.A.Dropped() == 0 || ok == 0 {
+		t.Fatalf("dropped %d bytes, %d calls succeeded", p.A.Dropped(), ok)
+	}
+}
@@ -0,0 +1,241 @@
+//mvctest 提供在内存中相连的一对Mvc，记录双方收发的帧，
+//用于在不建立TCP连接的情况下测试基于tcpmvc的控制器
+package mvctest
+
+import (
+	"encoding/json"
+	"net"
+	"pointTest/tcpProxy/tcpmvc"
+	"sync"
+	"testing"
+	"time"
+)
+
+//等待帧的默认超时
+const DefaultTimeout = 2 * time.Second
+
+//记录下的一帧
+type Frame struct {
+	Dir     tcpmvc.Direction
+	Tag     string
+	Payload []byte
+	Data    *tcpmvc.Data   //Data帧解码后的内容，其他帧为nil
+	Batch   []*tcpmvc.Data //批量帧解码后的内容，其他帧为nil
+}
+
+//帧中包含的所有Data
+func (f *Frame) Datas() []*tcpmvc.Data {
+	if f.Data != nil {
+		return []*tcpmvc.Data{f.Data}
+	}
+	return f.Batch
+}
+
+//帧记录器，Hook设置到Mvc后记录其收发的每一帧
+type Recorder struct {
+	mu     sync.Mutex
+	frames []Frame
+	notify chan struct{} //有新帧时关闭并替换，用于等待
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{notify: make(chan struct{})}
+}
+
+//返回设置到Mvc.Hook的帧观察者
+func (r *Recorder) Hook() tcpmvc.FrameHook {
+	return func(dir tcpmvc.Direction, tag string, payload []byte) {
+		f := Frame{Dir: dir, Tag: tag, Payload: append([]byte(nil), payload...)}
+		switch tag {
+		case tcpmvc.TAG:
+			var d tcpmvc.Data
+			if json.Unmarshal(f.Payload, &d) == nil {
+				f.Data = &d
+			}
+		case tcpmvc.BATCH_TAG:
+			json.Unmarshal(f.Payload, &f.Batch)
+		}
+		r.mu.Lock()
+		r.frames = append(r.frames, f)
+		close(r.notify)
+		r.notify = make(chan struct{})
+		r.mu.Unlock()
+	}
+}
+
+//目前记录的所有帧
+func (r *Recorder) Frames() []Frame {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Frame(nil), r.frames...)
+}
+
+//发出的Data
+func (r *Recorder) Sent() []*tcpmvc.Data {
+	return r.datas(tcpmvc.Outbound)
+}
+
+//收到的Data
+func (r *Recorder) Received() []*tcpmvc.Data {
+	return r.datas(tcpmvc.Inbound)
+}
+
+func (r *Recorder) datas(dir tcpmvc.Direction) []*tcpmvc.Data {
+	var out []*tcpmvc.Data
+	for _, f := range r.Frames() {
+		if f.Dir == dir {
+			out = append(out, f.Datas()...)
+		}
+	}
+	return out
+}
+
+//清空记录
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.frames = nil
+	r.mu.Unlock()
+}
+
+//等待指定方向上第一个匹配model、method的Data，超时返回nil
+func (r *Recorder) Wait(dir tcpmvc.Direction, model, method string, timeout time.Duration) *tcpmvc.Data {
+	deadline := time.After(timeout)
+	for {
+		r.mu.Lock()
+		for _, f := range r.frames {
+			if f.Dir != dir {
+				continue
+			}
+			for _, d := range f.Datas() {
+				if d.Model == model && d.Method == method {
+					r.mu.Unlock()
+					return d
+				}
+			}
+		}
+		notify := r.notify
+		r.mu.Unlock()
+		select {
+		case <-notify:
+		case <-deadline:
+			return nil
+		}
+	}
+}
+
+//断言在DefaultTimeout内收到了model.method，返回收到的Data
+func (r *Recorder) AssertReceived(t testing.TB, model, method string) *tcpmvc.Data {
+	t.Helper()
+	d := r.Wait(tcpmvc.Inbound, model, method, DefaultTimeout)
+	if d == nil {
+		t.Fatalf("mvctest: 未收到 %s.%s", model, method)
+	}
+	return d
+}
+
+//断言在DefaultTimeout内发出了model.method，返回发出的Data
+func (r *Recorder) AssertSent(t testing.TB, model, method string) *tcpmvc.Data {
+	t.Helper()
+	d := r.Wait(tcpmvc.Outbound, model, method, DefaultTimeout)
+	if d == nil {
+		t.Fatalf("mvctest: 未发出 %s.%s", model, method)
+	}
+	return d
+}
+
+//断言没有发出model.method
+func (r *Recorder) AssertNotSent(t testing.TB, model, method string) {
+	t.Helper()
+	for _, d := range r.Sent() {
+		if d.Model == model && d.Method == method {
+			t.Fatalf("mvctest: 不应发出 %s.%s", model, method)
+		}
+	}
+}
+
+//在内存中相连的一对Mvc，A与B各自记录收发的帧
+type Pair struct {
+	A, B       *tcpmvc.Mvc
+	RecA, RecB *Recorder
+
+	connA, connB net.Conn
+	started      bool
+	doneA, doneB chan struct{} //对应端的StartHandle返回后关闭
+	errA, errB   error         //对应端StartHandle的返回值
+}
+
+//建立一对相连的Mvc，controllers同时注册到A和B，并开始处理消息
+//t结束时自动关闭
+func NewPair(t testing.TB, controllers ...interface{}) *Pair {
+	p := NewPairConn(net.Pipe())
+	for _, c := range controllers {
+		p.A.Include(c)
+		p.B.Include(c)
+	}
+	p.Start()
+	t.Cleanup(p.Close)
+	return p
+}
+
+//以给定的两端连接建立Mvc，需要调用Start后才开始处理消息
+//可用于在两端之间插入自定义的net.Conn
+func NewPairConn(connA, connB net.Conn) *Pair {
+	p := &Pair{
+		A:     tcpmvc.New(connA),
+		B:     tcpmvc.New(connB),
+		RecA:  NewRecorder(),
+		RecB:  NewRecorder(),
+		connA: connA,
+		connB: connB,
+		doneA: make(chan struct{}),
+		doneB: make(chan struct{}),
+	}
+	p.A.Hook = p.RecA.Hook()
+	p.B.Hook = p.RecB.Hook()
+	return p
+}
+
+func (p *Pair) Start() {
+	p.started = true
+	go func() {
+		p.errA = p.A.StartHandle()
+		close(p.doneA)
+	}()
+	go func() {
+		p.errB = p.B.StartHandle()
+		close(p.doneB)
+	}()
+}
+
+//等待m(p.A或p.B)的处理结束，返回其StartHandle的返回值
+//timeout内没有结束时ended为false
+func (p *Pair) Wait(m *tcpmvc.Mvc, timeout time.Duration) (ended bool, err error) {
+	done, errp := p.doneA, &p.errA
+	if m == p.B {
+		done, errp = p.doneB, &p.errB
+	}
+	select {
+	case <-done:
+		return true, *errp
+	case <-time.After(timeout):
+		return false, nil
+	}
+}
+
+//关闭两端连接并等待处理结束
+func (p *Pair) Close() {
+	p.connA.Close()
+	p.connB.Close()
+	if !p.started {
+		return
+	}
+	p.started = false
+	timeout := time.After(DefaultTimeout)
+	for _, done := range []chan struct{}{p.doneA, p.doneB} {
+		select {
+		case <-done:
+		case <-timeout:
+			return
+		}
+	}
+}
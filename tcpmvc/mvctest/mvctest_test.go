@@ -0,0 +1,142 @@
+package mvctest
+
+import (
+	"io/ioutil"
+	"net"
+	"pointTest/tcpProxy/tcpmvc"
+	"sync"
+	"testing"
+	"time"
+)
+
+type echo struct {
+	m *tcpmvc.Mvc
+}
+
+func (e *echo) Ping(args map[string][]byte) {
+	data := tcpmvc.NewData()
+	data.Model = "echo"
+	data.Method = "Pong"
+	data.Args["msg"] = args["msg"]
+	e.m.Write(data)
+}
+
+func (e *echo) Pong(args map[string][]byte) {}
+
+func TestPair(t *testing.T) {
+	p := NewPairConn(net.Pipe())
+	p.A.Include(&echo{m: p.A})
+	p.B.Include(&echo{m: p.B})
+	p.Start()
+	defer p.Close()
+
+	data := tcpmvc.NewData()
+	data.Model = "echo"
+	data.Method = "Ping"
+	data.Args["msg"] = []byte("hi")
+	if err := p.A.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	p.RecA.AssertSent(t, "echo", "Ping")
+	p.RecB.AssertReceived(t, "echo", "Ping")
+	p.RecB.AssertSent(t, "echo", "Pong")
+	pong := p.RecA.AssertReceived(t, "echo", "Pong")
+	if string(pong.Args["msg"]) != "hi" {
+		t.Fatalf("got %q", pong.Args["msg"])
+	}
+	p.RecA.AssertNotSent(t, "echo", "Pong")
+}
+
+func TestPairStream(t *testing.T) {
+	p := NewPair(t)
+	s, err := p.A.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		s.Write([]byte("stream"))
+		s.Close()
+	}()
+	r, err := p.B.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil || string(got) != "stream" {
+		t.Fatalf("got %q, %v", got, err)
+	}
+	var streamFrames int
+	for _, f := range p.RecB.Frames() {
+		if f.Dir == tcpmvc.Inbound && f.Tag == tcpmvc.STREAM_TAG {
+			streamFrames++
+		}
+	}
+	if streamFrames != 3 {
+		t.Fatalf("recorded %d stream frames, want open, data and close", streamFrames)
+	}
+}
+
+type counter struct {
+	got chan struct{}
+}
+
+func (c *counter) Tick(args map[string][]byte) {
+	c.got <- struct{}{}
+}
+
+func TestPairBatch(t *testing.T) {
+	p := NewPairConn(net.Pipe())
+	p.A.BatchWindow = 20 * time.Millisecond
+	c := &counter{got: make(chan struct{}, 10)}
+	p.B.Include(c)
+	back := &counter{got: make(chan struct{}, 1)}
+	p.A.Include(back)
+	p.Start()
+	defer p.Close()
+
+	tick := func(m *tcpmvc.Mvc) {
+		data := tcpmvc.NewData()
+		data.Model = "counter"
+		data.Method = "Tick"
+		if err := m.Write(data); err != nil {
+			t.Error(err)
+		}
+	}
+	//B还未告知支持批量帧，A不合并
+	tick(p.A)
+	<-c.got
+	if f := p.RecA.Frames(); len(f) != 1 || f[0].Tag != tcpmvc.TAG || f[0].Data.GetMeta(tcpmvc.MetaCaps) == "" {
+		t.Fatalf("frames before caps %+v", f)
+	}
+	tick(p.B)
+	<-back.got
+	if !p.A.PeerSupports(tcpmvc.CapBatch) {
+		t.Fatal("peer caps not recorded")
+	}
+	p.RecA.Reset()
+	p.RecB.Reset()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			tick(p.A)
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 10; i++ {
+		select {
+		case <-c.got:
+		case <-time.After(DefaultTimeout):
+			t.Fatalf("dispatched %d of 10", i)
+		}
+	}
+	frames := p.RecA.Frames()
+	if len(frames) >= 10 {
+		t.Fatalf("%d frames for 10 messages, expected batching", len(frames))
+	}
+	if len(p.RecB.Received()) != 10 {
+		t.Fatalf("recorder saw %d messages", len(p.RecB.Received()))
+	}
+}
@@ -0,0 +1,283 @@
+package mvctrace
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//导出结束的span，Export在请求处理路径上调用，不能阻塞
+type Exporter interface {
+	Export(span *Span)
+	//导出尚未发送的span
+	Close() error
+}
+
+//按目标创建导出器：stdout为标准输出的JSON行，http://或https://开头为OTLP/HTTP的地址
+func NewExporter(target string) (Exporter, error) {
+	switch {
+	case target == "stdout":
+		return NewJSONExporter(os.Stdout), nil
+	case strings.HasPrefix(target, "http://"), strings.HasPrefix(target, "https://"):
+		return NewOTLPExporter(target), nil
+	}
+	return nil, errors.New("mvctrace:未知的导出目标" + target)
+}
+
+//每个span输出一行JSON
+type JSONExporter struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+func NewJSONExporter(w io.Writer) *JSONExporter {
+	return &JSONExporter{enc: json.NewEncoder(w)}
+}
+
+//JSON行中的一个span
+type jsonSpan struct {
+	Service    string            `json:"service"`
+	TraceID    string            `json:"traceId"`
+	SpanID     string            `json:"spanId"`
+	Parent     string            `json:"parentSpanId,omitempty"`
+	Name       string            `json:"name"`
+	Kind       Kind              `json:"kind"`
+	Start      time.Time         `json:"start"`
+	DurationMs float64           `json:"durationMs"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Err        string            `json:"error,omitempty"`
+}
+
+func (e *JSONExporter) Export(s *Span) {
+	js := jsonSpan{
+		Service:    s.Service(),
+		TraceID:    hex.EncodeToString(s.Context.TraceID[:]),
+		SpanID:     hex.EncodeToString(s.Context.SpanID[:]),
+		Name:       s.Name,
+		Kind:       s.Kind,
+		Start:      s.StartTime,
+		DurationMs: float64(s.EndTime.Sub(s.StartTime)) / float64(time.Millisecond),
+		Attributes: s.Attributes,
+		Err:        s.Err,
+	}
+	if s.Parent != (SpanID{}) {
+		js.Parent = hex.EncodeToString(s.Parent[:])
+	}
+	e.mu.Lock()
+	e.enc.Encode(&js)
+	e.mu.Unlock()
+}
+
+func (e *JSONExporter) Close() error {
+	return nil
+}
+
+const (
+	otlpQueue    = 1024            //等待发送的span上限，超过时丢弃
+	otlpBatch    = 256             //每次请求最多发送的span数
+	otlpInterval = 1 * time.Second //发送间隔
+)
+
+//以OTLP/HTTP的JSON编码把span批量发送到collector，例如 http://127.0.0.1:4318/v1/traces
+type OTLPExporter struct {
+	URL    string
+	Client *http.Client
+
+	queue   chan *Span
+	done    chan struct{}
+	closed  bool   //已Close，受mu保护
+	dropped uint64 //队列满或Close之后丢弃的span数，受mu保护
+	mu      sync.Mutex
+}
+
+func NewOTLPExporter(url string) *OTLPExporter {
+	e := &OTLPExporter{
+		URL:    url,
+		Client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan *Span, otlpQueue),
+		done:   make(chan struct{}),
+	}
+	go e.loop()
+	return e
+}
+
+//放入发送队列，队列满时丢弃，不让追踪拖慢请求
+//Close之后结束的span同样丢弃，例如关闭时仍在处理的请求
+func (e *OTLPExporter) Export(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		e.dropped++
+		return
+	}
+	select {
+	case e.queue <- s:
+	default:
+		e.dropped++
+	}
+}
+
+//发送剩余的span后返回，可以多次调用
+func (e *OTLPExporter) Close() error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.queue)
+	}
+	e.mu.Unlock()
+	<-e.done
+	return nil
+}
+
+//队列满或Close之后丢弃的span数
+func (e *OTLPExporter) Dropped() uint64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.dropped
+}
+
+func (e *OTLPExporter) loop() {
+	defer close(e.done)
+	ticker := time.NewTicker(otlpInterval)
+	defer ticker.Stop()
+	var batch []*Span
+	for {
+		select {
+		case s, ok := <-e.queue:
+			if !ok {
+				e.send(batch)
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) < otlpBatch {
+				continue
+			}
+		case <-ticker.C:
+		}
+		e.send(batch)
+		batch = batch[:0]
+	}
+}
+
+func (e *OTLPExporter) send(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(otlpRequest(batch))
+	if err != nil {
+		fmt.Println("mvctrace:编码span失败：" + err.Error())
+		return
+	}
+	resp, err := e.Client.Post(e.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		fmt.Println("mvctrace:发送span失败：" + err.Error())
+		return
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		fmt.Println("mvctrace:collector返回" + resp.Status)
+	}
+}
+
+//OTLP/HTTP JSON编码，只包含用到的字段
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID      string         `json:"traceId"`
+	SpanID       string         `json:"spanId"`
+	ParentSpanID string         `json:"parentSpanId,omitempty"`
+	Name         string         `json:"name"`
+	Kind         Kind           `json:"kind"`
+	Start        string         `json:"startTimeUnixNano"`
+	End          string         `json:"endTimeUnixNano"`
+	Attributes   []otlpKeyValue `json:"attributes,omitempty"`
+	Status       *otlpStatus    `json:"status,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"` //2为错误
+	Message string `json:"message,omitempty"`
+}
+
+func otlpAttributes(attrs map[string]string) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attrs))
+	for k, v := range attrs {
+		kvs = append(kvs, otlpKeyValue{Key: k, Value: otlpValue{StringValue: v}})
+	}
+	return kvs
+}
+
+//按服务分组编码一批span
+func otlpRequest(batch []*Span) *otlpTraces {
+	req := &otlpTraces{}
+	index := make(map[string]int)
+	for _, s := range batch {
+		i, ok := index[s.Service()]
+		if !ok {
+			i = len(req.ResourceSpans)
+			index[s.Service()] = i
+			req.ResourceSpans = append(req.ResourceSpans, otlpResourceSpans{
+				Resource:   otlpResource{Attributes: otlpAttributes(map[string]string{"service.name": s.Service()})},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "pointTest/tcpProxy/tcpmvc/mvctrace"}}},
+			})
+		}
+		span := otlpSpan{
+			TraceID:    hex.EncodeToString(s.Context.TraceID[:]),
+			SpanID:     hex.EncodeToString(s.Context.SpanID[:]),
+			Name:       s.Name,
+			Kind:       s.Kind,
+			Start:      strconv.FormatInt(s.StartTime.UnixNano(), 10),
+			End:        strconv.FormatInt(s.EndTime.UnixNano(), 10),
+			Attributes: otlpAttributes(s.Attributes),
+		}
+		if s.Parent != (SpanID{}) {
+			span.ParentSpanID = hex.EncodeToString(s.Parent[:])
+		}
+		if s.Err != "" {
+			span.Status = &otlpStatus{Code: 2, Message: s.Err}
+		}
+		scope := &req.ResourceSpans[i].ScopeSpans[0]
+		scope.Spans = append(scope.Spans, span)
+	}
+	return req
+}
@@ -0,0 +1,216 @@
+//mvctrace 在代理、隧道与后端之间传递W3C trace context，记录各段耗时的span并导出
+//追踪上下文在HTTP请求中为traceparent头，在tcpmvc中放在Data.Meta的同名项里
+package mvctrace
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"pointTest/tcpProxy/tcpmvc"
+	"sync"
+	"time"
+)
+
+//HTTP头与Data.Meta中保存追踪上下文的键
+const (
+	Header  = "Traceparent"
+	MetaKey = "traceparent"
+)
+
+type TraceID [16]byte
+type SpanID [8]byte
+
+//一个span在追踪中的位置，可以跨进程传递
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool //为false时继续传递但不导出
+}
+
+//追踪id与span id都不为0
+func (c SpanContext) IsValid() bool {
+	return c.TraceID != TraceID{} && c.SpanID != SpanID{}
+}
+
+//编码为traceparent格式：版本-追踪id-span id-标志位
+func (c SpanContext) String() string {
+	flags := "00"
+	if c.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(c.TraceID[:]) + "-" + hex.EncodeToString(c.SpanID[:]) + "-" + flags
+}
+
+//解析traceparent，格式错误时ok为false
+func Parse(s string) (c SpanContext, ok bool) {
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return c, false
+	}
+	//版本ff无效，00之外的版本可能在后面追加字段
+	if s[:2] == "ff" || (s[:2] == "00" && len(s) != 55) {
+		return c, false
+	}
+	var flags [1]byte
+	if _, err := hex.Decode(c.TraceID[:], []byte(s[3:35])); err != nil {
+		return c, false
+	}
+	if _, err := hex.Decode(c.SpanID[:], []byte(s[36:52])); err != nil {
+		return c, false
+	}
+	if _, err := hex.Decode(flags[:], []byte(s[53:55])); err != nil {
+		return c, false
+	}
+	c.Sampled = flags[0]&1 == 1
+	return c, c.IsValid()
+}
+
+//读取HTTP请求头中的追踪上下文
+func FromHeader(h http.Header) SpanContext {
+	c, _ := Parse(h.Get(Header))
+	return c
+}
+
+//把追踪上下文写入HTTP请求头
+func InjectHeader(h http.Header, c SpanContext) {
+	if c.IsValid() {
+		h.Set(Header, c.String())
+	}
+}
+
+//读取Data中的追踪上下文
+func Extract(data *tcpmvc.Data) SpanContext {
+	c, _ := Parse(data.GetMeta(MetaKey))
+	return c
+}
+
+//把追踪上下文写入Data
+func Inject(data *tcpmvc.Data, c SpanContext) {
+	if c.IsValid() {
+		data.SetMeta(MetaKey, c.String())
+	}
+}
+
+//span的类型，数值与OTLP相同
+type Kind int
+
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2 //处理收到的请求
+	KindClient   Kind = 3 //发出请求并等待回复
+)
+
+//一段被记录的操作
+type Span struct {
+	Name       string
+	Kind       Kind
+	Context    SpanContext
+	Parent     SpanID //为0时是追踪的根
+	StartTime  time.Time
+	EndTime    time.Time
+	Attributes map[string]string
+	Err        string //不为空时span以错误结束
+
+	tracer *Tracer
+	mu     sync.Mutex
+	ended  bool
+}
+
+//设置属性，nil的span忽略
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]string)
+	}
+	s.Attributes[key] = value
+	s.mu.Unlock()
+}
+
+//把span标记为错误
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Err = err.Error()
+	s.mu.Unlock()
+}
+
+//span的上下文，nil的span返回无效的上下文
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+//结束span并交给导出器，重复调用只导出一次
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+	if s.Context.Sampled && s.tracer.Exporter != nil {
+		s.tracer.Exporter.Export(s)
+	}
+}
+
+//span所属服务
+func (s *Span) Service() string {
+	return s.tracer.Service
+}
+
+//创建span，nil的Tracer表示不追踪，返回的nil span可以正常调用
+type Tracer struct {
+	Service  string //服务名，导出时作为service.name
+	Exporter Exporter
+}
+
+func New(service string, e Exporter) *Tracer {
+	return &Tracer{Service: service, Exporter: e}
+}
+
+//开始一个span，parent无效时开始新的追踪
+func (t *Tracer) Start(name string, kind Kind, parent SpanContext) *Span {
+	if t == nil {
+		return nil
+	}
+	s := &Span{Name: name, Kind: kind, StartTime: time.Now(), tracer: t}
+	if parent.IsValid() {
+		s.Context.TraceID = parent.TraceID
+		s.Context.Sampled = parent.Sampled
+		s.Parent = parent.SpanID
+	} else {
+		rand.Read(s.Context.TraceID[:])
+		s.Context.Sampled = true
+	}
+	rand.Read(s.Context.SpanID[:])
+	return s
+}
+
+//为收到的每个Data记录一个服务端span，并把Data中的追踪上下文换成该span，
+//处理方法从Data中Extract得到的即是这个span，再向外发出的请求成为它的子span
+func (t *Tracer) Interceptor() tcpmvc.Interceptor {
+	return func(data *tcpmvc.Data, next func(data *tcpmvc.Data)) {
+		span := t.Start(data.Model+"."+data.Method, KindServer, Extract(data))
+		if span == nil {
+			next(data)
+			return
+		}
+		defer span.End()
+		span.SetAttribute("rpc.system", "tcpmvc")
+		span.SetAttribute("rpc.service", data.Model)
+		span.SetAttribute("rpc.method", data.Method)
+		Inject(data, span.SpanContext())
+		next(data)
+	}
+}
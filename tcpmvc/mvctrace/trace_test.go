@@ -0,0 +1,192 @@
+package mvctrace
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"pointTest/tcpProxy/tcpmvc"
+	"pointTest/tcpProxy/tcpmvc/mvctest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	s := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	c, ok := Parse(s)
+	if !ok || !c.Sampled || c.String() != s {
+		t.Fatalf("parse %q: %+v %v", s, c, ok)
+	}
+	for _, bad := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		if _, ok := Parse(bad); ok {
+			t.Fatalf("%q should be invalid", bad)
+		}
+	}
+}
+
+//记录导出的span
+type memExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+func (e *memExporter) Export(s *Span) {
+	e.mu.Lock()
+	e.spans = append(e.spans, s)
+	e.mu.Unlock()
+}
+
+func (e *memExporter) count() int {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return len(e.spans)
+}
+
+func (e *memExporter) Close() error {
+	return nil
+}
+
+type backend struct {
+	tracer *Tracer
+	done   chan SpanContext
+}
+
+func (b *backend) Request(data *tcpmvc.Data) {
+	span := b.tracer.Start("upstream", KindClient, Extract(data))
+	span.End()
+	b.done <- span.SpanContext()
+}
+
+func TestPropagation(t *testing.T) {
+	exp := &memExporter{}
+	proxy := New("proxy", exp)
+	client := New("client", exp)
+	p := mvctest.NewPairConn(net.Pipe())
+	b := &backend{tracer: client, done: make(chan SpanContext, 1)}
+	p.B.Include(b)
+	p.B.Use(client.Interceptor())
+	p.Start()
+	defer p.Close()
+
+	h := http.Header{}
+	h.Set(Header, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	root := proxy.Start("HTTP GET", KindServer, FromHeader(h))
+	tunnel := proxy.Start("tunnel", KindClient, root.SpanContext())
+	data := tcpmvc.NewData()
+	data.Model = "backend"
+	data.Method = "Request"
+	Inject(data, tunnel.SpanContext())
+	if err := p.A.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	var up SpanContext
+	select {
+	case up = <-b.done:
+	case <-time.After(time.Second):
+		t.Fatal("request was not dispatched")
+	}
+	tunnel.End()
+	root.End()
+	p.RecA.AssertSent(t, "backend", "Request")
+
+	//服务端span在处理方法返回后才结束
+	for i := 0; i < 100 && exp.count() < 4; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	exp.mu.Lock()
+	defer exp.mu.Unlock()
+	byName := make(map[string]*Span)
+	for _, s := range exp.spans {
+		byName[s.Name] = s
+		if s.Context.TraceID != root.Context.TraceID {
+			t.Fatalf("%s has trace %x", s.Name, s.Context.TraceID)
+		}
+	}
+	server := byName["backend.Request"]
+	if len(exp.spans) != 4 || server == nil || server.Kind != KindServer {
+		t.Fatalf("exported %d spans, server span %v", len(exp.spans), server)
+	}
+	if root.Parent != (SpanID{0x00, 0xf0, 0x67, 0xaa, 0x0b, 0xa9, 0x02, 0xb7}) {
+		t.Fatalf("root parent %x", root.Parent)
+	}
+	if server.Parent != tunnel.Context.SpanID || byName["upstream"].Parent != server.Context.SpanID {
+		t.Fatal("spans are not linked parent to child")
+	}
+	if byName["upstream"].Context != up {
+		t.Fatal("handler did not see the server span context")
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tracer *Tracer
+	span := tracer.Start("noop", KindInternal, SpanContext{})
+	span.SetAttribute("k", "v")
+	span.End()
+	if span.SpanContext().IsValid() {
+		t.Fatal("nil tracer should not create spans")
+	}
+	data := tcpmvc.NewData()
+	Inject(data, span.SpanContext())
+	if data.Meta != nil {
+		t.Fatalf("invalid context injected: %v", data.Meta)
+	}
+}
+
+func TestExporters(t *testing.T) {
+	var buf bytes.Buffer
+	tracer := New("svc", NewJSONExporter(&buf))
+	span := tracer.Start("op", KindServer, SpanContext{})
+	span.SetAttribute("k", "v")
+	span.End()
+	span.End()
+	var line map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("%v: %s", err, buf.Bytes())
+	}
+	if line["name"] != "op" || line["service"] != "svc" || bytes.Count(buf.Bytes(), []byte("\n")) != 1 {
+		t.Fatalf("json line %s", buf.Bytes())
+	}
+
+	got := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		got <- body
+	}))
+	defer srv.Close()
+	otlp := NewOTLPExporter(srv.URL + "/v1/traces")
+	tracer = New("svc", otlp)
+	span = tracer.Start("op", KindClient, SpanContext{})
+	span.SetError(tcpmvc.ErrDeadlineExceeded)
+	span.End()
+	otlp.Close()
+	var req otlpTraces
+	if err := json.Unmarshal(<-got, &req); err != nil {
+		t.Fatal(err)
+	}
+	rs := req.ResourceSpans
+	if len(rs) != 1 || rs[0].Resource.Attributes[0].Value.StringValue != "svc" {
+		t.Fatalf("resource %+v", rs)
+	}
+	s := rs[0].ScopeSpans[0].Spans[0]
+	if s.Name != "op" || s.Kind != KindClient || s.Status == nil || s.Status.Code != 2 || len(s.TraceID) != 32 {
+		t.Fatalf("span %+v", s)
+	}
+
+	//关闭后仍在进行的请求结束span时丢弃，而不是向已关闭的队列发送
+	late := tracer.Start("late", KindServer, SpanContext{})
+	late.End()
+	otlp.Close()
+	if otlp.Dropped() != 1 {
+		t.Fatalf("dropped %d", otlp.Dropped())
+	}
+}
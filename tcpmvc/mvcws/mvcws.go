@@ -0,0 +1,429 @@
+//mvcws 让tcpmvc运行在WebSocket连接上(RFC 6455)，用于只允许出站HTTP(S)的网络环境
+//服务端用Upgrade接受连接，客户端用Dial连接ws://或wss://地址，得到的*Conn是net.Conn，
+//可以直接交给tcpmvc.New；tcpmvc的字节流放在二进制消息中传输，消息边界没有意义
+package mvcws
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+//握手时计算Sec-WebSocket-Accept用的GUID
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+//单个数据帧的最大长度，与tcpmvc.DefaultMaxFrame一致
+const MaxFrameSize = 64 << 20
+
+//帧类型
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+//关闭帧的状态码
+const (
+	CloseNormal        = 1000
+	CloseProtocolError = 1002
+	CloseTooBig        = 1009
+)
+
+var (
+	ErrHandshake = errors.New("WebSocket握手失败")
+	ErrProtocol  = errors.New("WebSocket帧格式错误")
+	ErrFrameSize = errors.New("WebSocket帧超过最大长度")
+)
+
+//对端发来的关闭帧
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("WebSocket连接已关闭(%d):%s", e.Code, e.Reason)
+}
+
+//WebSocket连接，Read返回数据帧的内容，每次Write发送一个二进制帧
+//ping由Read自动回复pong；Read与Write可以在不同goroutine中同时调用
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool //客户端发送的帧需要掩码
+
+	wmu    sync.Mutex
+	closed int32 //已发送关闭帧
+
+	//当前数据帧的读取状态，只在Read中使用
+	remain  int64
+	masked  bool
+	mask    [4]byte
+	maskPos int
+}
+
+func newConn(c net.Conn, br *bufio.Reader, client bool) *Conn {
+	if br == nil {
+		br = bufio.NewReader(c)
+	}
+	return &Conn{conn: c, br: br, client: client}
+}
+
+func (c *Conn) Read(p []byte) (int, error) {
+	for c.remain == 0 {
+		if err := c.nextFrame(); err != nil {
+			return 0, err
+		}
+	}
+	if int64(len(p)) > c.remain {
+		p = p[:c.remain]
+	}
+	n, err := c.br.Read(p)
+	if c.masked {
+		for i := 0; i < n; i++ {
+			p[i] ^= c.mask[c.maskPos&3]
+			c.maskPos++
+		}
+	}
+	c.remain -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+//读取下一个帧头，控制帧在这里处理完，数据帧留给Read读取内容
+func (c *Conn) nextFrame() error {
+	var head [2]byte
+	if _, err := io.ReadFull(c.br, head[:]); err != nil {
+		return err
+	}
+	if head[0]&0x70 != 0 {
+		c.closeWith(CloseProtocolError, "RSV")
+		return ErrProtocol
+	}
+	op := head[0] & 0x0f
+	masked := head[1]&0x80 != 0
+	length := int64(head[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return err
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+	if length < 0 || length > MaxFrameSize {
+		c.closeWith(CloseTooBig, "")
+		return ErrFrameSize
+	}
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return err
+		}
+	}
+
+	switch op {
+	case opContinuation, opText, opBinary:
+		c.remain, c.masked, c.mask, c.maskPos = length, masked, mask, 0
+		return nil
+	case opClose, opPing, opPong:
+		//控制帧不超过125字节且不能分片
+		if length > 125 || head[0]&0x80 == 0 {
+			c.closeWith(CloseProtocolError, "控制帧")
+			return ErrProtocol
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return err
+		}
+		if masked {
+			for i := range payload {
+				payload[i] ^= mask[i&3]
+			}
+		}
+		switch op {
+		case opPing:
+			c.wmu.Lock()
+			err := c.writeFrame(opPong, payload)
+			c.wmu.Unlock()
+			return err
+		case opClose:
+			cerr := &CloseError{Code: CloseNormal}
+			if len(payload) >= 2 {
+				cerr.Code = int(binary.BigEndian.Uint16(payload))
+				cerr.Reason = string(payload[2:])
+			}
+			c.closeWith(CloseNormal, "")
+			if cerr.Code == CloseNormal {
+				return io.EOF
+			}
+			return cerr
+		}
+		return nil
+	}
+	c.closeWith(CloseProtocolError, "未知帧类型")
+	return ErrProtocol
+}
+
+func (c *Conn) Write(p []byte) (int, error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if atomic.LoadInt32(&c.closed) != 0 {
+		return 0, net.ErrClosed
+	}
+	if err := c.writeFrame(opBinary, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+//写一个完整的帧，调用方持有wmu
+func (c *Conn) writeFrame(op byte, payload []byte) error {
+	head := make([]byte, 2, 14)
+	head[0] = 0x80 | op
+	switch n := len(payload); {
+	case n <= 125:
+		head[1] = byte(n)
+	case n <= 0xffff:
+		head[1] = 126
+		head = head[:4]
+		binary.BigEndian.PutUint16(head[2:], uint16(n))
+	default:
+		head[1] = 127
+		head = head[:10]
+		binary.BigEndian.PutUint64(head[2:], uint64(n))
+	}
+	if !c.client {
+		bufs := net.Buffers{head, payload}
+		_, err := bufs.WriteTo(c.conn)
+		return err
+	}
+	//客户端的帧用随机掩码，掩码后的内容写入新缓冲区，不修改调用方的数据
+	var mask [4]byte
+	if _, err := rand.Read(mask[:]); err != nil {
+		return err
+	}
+	head[1] |= 0x80
+	head = append(head, mask[:]...)
+	buf := make([]byte, len(head)+len(payload))
+	copy(buf, head)
+	body := buf[len(head):]
+	for i, b := range payload {
+		body[i] = b ^ mask[i&3]
+	}
+	_, err := c.conn.Write(buf)
+	return err
+}
+
+//发送关闭帧，只发送一次
+func (c *Conn) closeWith(code int, reason string) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.writeClose(code, reason)
+}
+
+//写关闭帧，调用方持有wmu
+func (c *Conn) writeClose(code int, reason string) {
+	if !atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		return
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+	//对端可能已不再读取，不为关闭帧等待太久
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrame(opClose, payload)
+	c.conn.SetWriteDeadline(time.Time{})
+}
+
+//发送关闭帧后关闭底层连接
+//有Write阻塞在不再读取的对端上时不等待wmu、不发送关闭帧，直接关闭底层连接使其返回
+func (c *Conn) Close() error {
+	if c.wmu.TryLock() {
+		c.writeClose(CloseNormal, "")
+		c.wmu.Unlock()
+	}
+	return c.conn.Close()
+}
+
+func (c *Conn) LocalAddr() net.Addr                { return c.conn.LocalAddr() }
+func (c *Conn) RemoteAddr() net.Addr               { return c.conn.RemoteAddr() }
+func (c *Conn) SetDeadline(t time.Time) error      { return c.conn.SetDeadline(t) }
+func (c *Conn) SetReadDeadline(t time.Time) error  { return c.conn.SetReadDeadline(t) }
+func (c *Conn) SetWriteDeadline(t time.Time) error { return c.conn.SetWriteDeadline(t) }
+
+//请求是否为WebSocket握手
+func IsWebSocket(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+//接受WebSocket握手并接管HTTP连接，握手失败时已向客户端返回错误
+func Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsWebSocket(r) {
+		http.Error(w, "需要WebSocket握手", http.StatusBadRequest)
+		return nil, ErrHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "只支持WebSocket版本13", http.StatusUpgradeRequired)
+		return nil, ErrHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		http.Error(w, "缺少Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, ErrHandshake
+	}
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "连接不支持接管", http.StatusInternalServerError)
+		return nil, ErrHandshake
+	}
+	c, rw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	//Hijack前设置的超时仍然有效，tcpmvc连接是长连接
+	c.SetDeadline(time.Time{})
+	_, err = fmt.Fprintf(c, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", acceptKey(key))
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return newConn(c, rw.Reader, false), nil
+}
+
+//连接ws://或wss://地址，config为nil时wss使用默认的TLS配置
+func Dial(rawurl string, config *tls.Config) (*Conn, error) {
+	return DialWith(net.Dial, rawurl, config)
+}
+
+//与Dial相同，但用dial建立TCP连接，例如经过出站代理
+func DialWith(dial func(network, addr string) (net.Conn, error), rawurl string, config *tls.Config) (*Conn, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	if u.Port() == "" {
+		switch u.Scheme {
+		case "ws":
+			host = net.JoinHostPort(u.Hostname(), "80")
+		case "wss":
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return nil, fmt.Errorf("%w:不支持的地址%s", ErrHandshake, rawurl)
+	}
+	c, err := dial("tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "wss" {
+		if config == nil {
+			config = &tls.Config{}
+		}
+		if config.ServerName == "" {
+			config = config.Clone()
+			config.ServerName = u.Hostname()
+		}
+		tc := tls.Client(c, config)
+		if err := tc.Handshake(); err != nil {
+			c.Close()
+			return nil, err
+		}
+		c = tc
+	}
+	ws, err := handshake(c, u)
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	return ws, nil
+}
+
+//在已建立的连接上发起握手
+func handshake(c net.Conn, u *url.URL) (*Conn, error) {
+	var nonce [16]byte
+	if _, err := rand.Read(nonce[:]); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(nonce[:])
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Scheme: "http", Host: u.Host, Path: u.Path, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Host:       u.Host,
+		Header: http.Header{
+			"Upgrade":               {"websocket"},
+			"Connection":            {"Upgrade"},
+			"Sec-WebSocket-Key":     {key},
+			"Sec-WebSocket-Version": {"13"},
+		},
+	}
+	if u.User != nil {
+		password, _ := u.User.Password()
+		req.SetBasicAuth(u.User.Username(), password)
+	}
+	if err := req.Write(c); err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(c)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w:%s", ErrHandshake, resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, fmt.Errorf("%w:Sec-WebSocket-Accept不匹配", ErrHandshake)
+	}
+	return newConn(c, br, true), nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key))
+	h.Write([]byte(acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+//头中逗号分隔的值是否包含token，不区分大小写
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h[http.CanonicalHeaderKey(name)] {
+		for _, s := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(s), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
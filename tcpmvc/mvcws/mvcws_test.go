@@ -0,0 +1,148 @@
+package mvcws
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"pointTest/tcpProxy/tcpmvc"
+	"strings"
+	"testing"
+	"time"
+)
+
+type echoModel struct{}
+
+func (e *echoModel) Echo(args map[string][]byte) map[string]string {
+	return map[string]string{"msg": string(args["msg"])}
+}
+
+//服务端接受的连接交给ch
+func wsPair(t *testing.T) (*Conn, *Conn) {
+	ch := make(chan *Conn, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := Upgrade(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ch <- c
+	}))
+	t.Cleanup(srv.Close)
+	client, err := Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/_tunnel", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := <-ch
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server
+}
+
+func TestMvcOverWebSocket(t *testing.T) {
+	client, server := wsPair(t)
+	a, b := tcpmvc.New(client), tcpmvc.New(server)
+	b.Include(&echoModel{})
+	go a.StartHandle()
+	go b.StartHandle()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	data := tcpmvc.NewData()
+	data.Model = "echoModel"
+	data.Method = "Echo"
+	//超过65535字节，使用8字节长度的帧
+	data.Args["msg"] = bytes.Repeat([]byte("x"), 100000)
+	result, err := a.Call(ctx, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 100000+len(`{"msg":""}`) {
+		t.Fatalf("result length %d", len(result))
+	}
+}
+
+func TestControlFrames(t *testing.T) {
+	client, server := wsPair(t)
+	//服务端收到ping后自动回复pong，客户端的Read跳过pong
+	client.wmu.Lock()
+	client.writeFrame(opPing, []byte("hi"))
+	client.wmu.Unlock()
+	go func() {
+		buf := make([]byte, 16)
+		n, err := server.Read(buf)
+		if err != nil || string(buf[:n]) != "data" {
+			t.Errorf("server read %q %v", buf[:n], err)
+		}
+		server.Close()
+	}()
+	if _, err := client.Write([]byte("data")); err != nil {
+		t.Fatal(err)
+	}
+	//关闭帧前的pong被跳过，关闭后Read返回EOF
+	buf := make([]byte, 16)
+	if n, err := client.Read(buf); err != io.EOF {
+		t.Fatalf("client read %q %v", buf[:n], err)
+	}
+}
+
+func TestUpgradeRejects(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := Upgrade(w, r); err == nil {
+			t.Error("plain request upgraded")
+		}
+	}))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("status %d", resp.StatusCode)
+	}
+	if _, err := Dial("http"+strings.TrimPrefix(srv.URL, "http"), nil); err == nil {
+		t.Fatal("dial with http scheme")
+	}
+}
+
+//Write阻塞在不再读取的对端上时，Close不等待写锁，关闭连接后Write返回错误
+func TestCloseStalledWrite(t *testing.T) {
+	a, b := net.Pipe()
+	defer b.Close()
+	c := newConn(a, nil, false)
+	written := make(chan error, 1)
+	go func() {
+		_, err := c.Write([]byte("never read"))
+		written <- err
+	}()
+	//等到Write持有写锁、阻塞在对端上
+	deadline := time.Now().Add(2 * time.Second)
+	for c.wmu.TryLock() {
+		c.wmu.Unlock()
+		if time.Now().After(deadline) {
+			t.Fatal("Write did not start")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- c.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Close blocked on a stalled Write")
+	}
+	select {
+	case err := <-written:
+		if err == nil {
+			t.Fatal("stalled Write succeeded after Close")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("stalled Write not released by Close")
+	}
+}
@@ -0,0 +1,315 @@
+package tcpmvc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+//JSON-RPC 2.0兼容模式，供其他语言的服务使用现成的JSON-RPC库连接
+//消息可以放在RPC_TAG帧中，也可以不加帧头、每行一条（连接的第一个字节为{或[时）
+//方法名为"Model.Method"，params必须是对象，字符串值作为参数的原始字节，其他值保留JSON文本
+//处理方法的返回值作为result，返回的error作为error；通知（没有id）不回复
+//连接的第一帧或第一行是JSON-RPC时，Write发送的Data变为JSON-RPC通知，参数均编码为字符串；
+//之后原生协议的对端偶尔发来的rpc|帧照常处理，但不改变本端的写法
+//参数与结果中不是合法UTF-8的字节无法表示为JSON字符串，编码时返回错误而不是替换为U+FFFD
+
+//JSON-RPC规定的错误码
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+	RPCServerError    = -32000 //处理方法返回的普通错误
+)
+
+//对端的JSON-RPC模式
+const (
+	rpcFramed int32 = 1 //JSON-RPC消息放在RPC_TAG帧中
+	rpcLines  int32 = 2 //每行一条JSON-RPC消息
+)
+
+//JSON-RPC错误，处理方法可以直接返回本类型以指定错误码与附加数据
+type RPCError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+func (e *RPCError) Error() string {
+	return fmt.Sprintf("tcpmvc:JSON-RPC错误%d:%s", e.Code, e.Message)
+}
+
+type rpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"`
+}
+
+type rpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *RPCError       `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type rpcNotification struct {
+	Version string            `json:"jsonrpc"`
+	Method  string            `json:"method"`
+	Params  map[string]string `json:"params"`
+}
+
+var rpcNull = json.RawMessage("null")
+
+//一次JSON-RPC调用，回复只发送一次
+type rpcCall struct {
+	m     *Mvc
+	id    json.RawMessage
+	batch *rpcBatch //批量请求中的调用，回复交给批次一起发送
+	done  int32
+}
+
+func (c *rpcCall) reply(result interface{}, err error) {
+	if !atomic.CompareAndSwapInt32(&c.done, 0, 1) {
+		return
+	}
+	resp := &rpcResponse{Version: "2.0", ID: c.id}
+	if err != nil {
+		resp.Error = rpcErrorOf(err)
+	} else if resp.Result, err = rpcResult(result); err != nil {
+		resp.Error = &RPCError{Code: RPCInternalError, Message: StatusEncodeFail + err.Error()}
+	}
+	if c.batch != nil {
+		c.batch.add(resp)
+		return
+	}
+	c.m.writeRPC(resp)
+}
+
+//批量请求，所有调用都回复后作为一个数组发送
+type rpcBatch struct {
+	m         *Mvc
+	mu        sync.Mutex
+	pending   int
+	responses []*rpcResponse
+}
+
+func (b *rpcBatch) add(resp *rpcResponse) {
+	b.mu.Lock()
+	b.responses = append(b.responses, resp)
+	b.pending--
+	done := b.pending == 0
+	b.mu.Unlock()
+	if done {
+		b.m.writeRPC(b.responses)
+	}
+}
+
+//由连接的第一帧确定对端是否使用JSON-RPC
+func (m *Mvc) detectRPC(tag string) {
+	if tag != RPC_TAG {
+		return
+	}
+	mode := rpcFramed
+	if m.decoder != nil && m.decoder.Lines() {
+		mode = rpcLines
+	}
+	atomic.StoreInt32(&m.rpc, mode)
+}
+
+//处理收到的JSON-RPC消息
+func (m *Mvc) handleRPC(raw []byte) error {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) > 0 && raw[0] == '[' {
+		var msgs []json.RawMessage
+		if err := json.Unmarshal(raw, &msgs); err != nil {
+			go m.writeRPC(rpcFail(nil, RPCParseError, err.Error()))
+			return nil
+		}
+		if len(msgs) == 0 {
+			go m.writeRPC(rpcFail(nil, RPCInvalidRequest, "空的批量请求"))
+			return nil
+		}
+		reqs := make([]*rpcRequest, len(msgs))
+		b := &rpcBatch{m: m}
+		for i, msg := range msgs {
+			var req rpcRequest
+			if json.Unmarshal(msg, &req) != nil {
+				req = rpcRequest{ID: rpcNull}
+			}
+			reqs[i] = &req
+			if req.ID != nil {
+				b.pending++
+			}
+		}
+		if b.pending == 0 {
+			b = nil
+		}
+		for _, req := range reqs {
+			m.handleRPCRequest(req, b)
+		}
+		return nil
+	}
+	var req rpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		go m.writeRPC(rpcFail(nil, RPCParseError, err.Error()))
+		return nil
+	}
+	m.handleRPCRequest(&req, nil)
+	return nil
+}
+
+//把一个JSON-RPC请求转为Data分发，格式错误时直接回复
+func (m *Mvc) handleRPCRequest(req *rpcRequest, b *rpcBatch) {
+	var call *rpcCall
+	if req.ID != nil {
+		call = &rpcCall{m: m, id: req.ID, batch: b}
+	}
+	if req.Method == "" {
+		//对端发来的回复，本端不发出调用，只记录
+		if call != nil && req.Version == "2.0" && b == nil {
+			fmt.Printf("tcpmvc:忽略JSON-RPC回复:%s\n", req.ID)
+			return
+		}
+		if call != nil {
+			go call.reply(nil, &RPCError{Code: RPCInvalidRequest, Message: "缺少method"})
+		}
+		return
+	}
+	if req.Version != "2.0" {
+		if call != nil {
+			go call.reply(nil, &RPCError{Code: RPCInvalidRequest, Message: "jsonrpc必须为2.0"})
+		}
+		return
+	}
+	dot := strings.LastIndexByte(req.Method, '.')
+	if dot <= 0 {
+		if call != nil {
+			go call.reply(nil, &RPCError{Code: RPCMethodNotFound, Message: "方法名应为Model.Method:" + req.Method})
+		}
+		return
+	}
+	args, err := rpcArgs(req.Params)
+	if err != nil {
+		if call != nil {
+			go call.reply(nil, &RPCError{Code: RPCInvalidParams, Message: "params必须是对象:" + err.Error()})
+		}
+		return
+	}
+	data := &Data{Model: req.Method[:dot], Method: req.Method[dot+1:], Args: args}
+	if call != nil {
+		data.call = call
+	}
+	m.dispatch(data)
+}
+
+//params对象转为Args，字符串值取其内容，其他值保留JSON文本
+func rpcArgs(params json.RawMessage) (map[string][]byte, error) {
+	args := make(map[string][]byte)
+	if len(params) == 0 || bytes.Equal(params, rpcNull) {
+		return args, nil
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(params, &obj); err != nil {
+		return nil, err
+	}
+	for k, v := range obj {
+		var s string
+		if len(v) > 0 && v[0] == '"' && json.Unmarshal(v, &s) == nil {
+			args[k] = []byte(s)
+			continue
+		}
+		args[k] = v
+	}
+	return args, nil
+}
+
+//Args转为params对象，值均为字符串，不是合法UTF-8的值返回错误
+func rpcParams(args map[string][]byte) (map[string]string, error) {
+	params := make(map[string]string, len(args))
+	for k, v := range args {
+		if !utf8.Valid(v) {
+			return nil, newError(CodeEncodeFail, "参数"+k+"不是合法的UTF-8，无法编码为JSON字符串", nil)
+		}
+		params[k] = string(v)
+	}
+	return params, nil
+}
+
+//处理方法的返回值编码为result，Args形式的结果与params相同编码为字符串
+func rpcResult(result interface{}) (json.RawMessage, error) {
+	switch r := result.(type) {
+	case nil:
+		return rpcNull, nil
+	case json.RawMessage:
+		return r, nil
+	case []byte:
+		if !utf8.Valid(r) {
+			return nil, newError(CodeEncodeFail, "结果不是合法的UTF-8，无法编码为JSON字符串", nil)
+		}
+		return json.Marshal(string(r))
+	case map[string][]byte:
+		params, err := rpcParams(r)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(params)
+	}
+	return json.Marshal(result)
+}
+
+//错误转为JSON-RPC错误，本包的错误使用对应的错误码，未知Model与Method使用JSON-RPC的错误码
+func rpcErrorOf(err error) *RPCError {
+	var rpcErr *RPCError
+	if errors.As(err, &rpcErr) {
+		return rpcErr
+	}
+	code, message := Code(0), err.Error()
+	var perr *ProtocolError
+	var rerr *RemoteError
+	if errors.As(err, &perr) {
+		code = perr.Code
+	} else if errors.As(err, &rerr) {
+		//分发时的错误，与错误帧内容相同
+		code, message = rerr.Code, rerr.Message
+	}
+	switch code {
+	case 0:
+		return &RPCError{Code: RPCServerError, Message: message}
+	case CodeUnkonwModel, CodeUnkonwMethod:
+		return &RPCError{Code: RPCMethodNotFound, Message: message}
+	}
+	return &RPCError{Code: int(code), Message: message}
+}
+
+func rpcFail(id json.RawMessage, code int, message string) *rpcResponse {
+	if id == nil {
+		id = rpcNull
+	}
+	return &rpcResponse{Version: "2.0", Error: &RPCError{Code: code, Message: message}, ID: id}
+}
+
+//发送一条JSON-RPC消息
+func (m *Mvc) writeRPC(v interface{}) error {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return newError(CodeEncodeFail, "", err)
+	}
+	return m.writeFrame(RPC_TAG, nil, payload)
+}
+
+//把Data作为JSON-RPC通知发送
+func (m *Mvc) writeNotification(data *Data) error {
+	params, err := rpcParams(data.Args)
+	if err != nil {
+		return err
+	}
+	return m.writeRPC(&rpcNotification{Version: "2.0", Method: data.Model + "." + data.Method, Params: params})
+}
@@ -0,0 +1,213 @@
+package tcpmvc
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+type rpcModel struct {
+	m        *Mvc
+	notified chan string
+}
+
+func (r *rpcModel) Add(args map[string][]byte) (int, error) {
+	a, err := strconv.Atoi(string(args["a"]))
+	if err != nil {
+		return 0, err
+	}
+	b, err := strconv.Atoi(string(args["b"]))
+	if err != nil {
+		return 0, &RPCError{Code: 1001, Message: "b不是整数"}
+	}
+	return a + b, nil
+}
+
+func (r *rpcModel) Echo(args map[string][]byte) map[string][]byte {
+	return args
+}
+
+func (r *rpcModel) Notify(args map[string][]byte) {
+	r.notified <- string(args["msg"])
+	//对端使用JSON-RPC时，发送的Data成为通知
+	data := NewData()
+	data.Model = "peer"
+	data.Method = "Notified"
+	data.Args["msg"] = args["msg"]
+	r.m.Write(data)
+}
+
+func rpcServer(t *testing.T) (*rpcModel, net.Conn) {
+	local, remote := net.Pipe()
+	t.Cleanup(func() { remote.Close() })
+	m := New(local)
+	r := &rpcModel{m: m, notified: make(chan string, 1)}
+	m.Include(r)
+	go m.StartHandle()
+	return r, remote
+}
+
+//读取一行回复
+func readLine(t *testing.T, conn net.Conn, br *bufio.Reader) map[string]interface{} {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v map[string]interface{}
+	if err := json.Unmarshal(line, &v); err != nil {
+		t.Fatalf("%v: %s", err, line)
+	}
+	return v
+}
+
+func TestJSONRPCLines(t *testing.T) {
+	r, conn := rpcServer(t)
+	br := bufio.NewReader(conn)
+	for _, c := range []struct {
+		req  string
+		want string
+	}{
+		{`{"jsonrpc":"2.0","method":"rpcModel.Add","params":{"a":"1","b":2},"id":1}`, `{"id":1,"jsonrpc":"2.0","result":3}`},
+		{`{"jsonrpc":"2.0","method":"rpcModel.Echo","params":{"k":"v"},"id":"s"}`, `{"id":"s","jsonrpc":"2.0","result":{"k":"v"}}`},
+		{`{"jsonrpc":"2.0","method":"rpcModel.Add","params":{"a":"1","b":"x"},"id":2}`, `{"error":{"code":1001,"message":"b不是整数"},"id":2,"jsonrpc":"2.0"}`},
+		{`{"jsonrpc":"2.0","method":"rpcModel.Missing","id":3}`, `{"error":{"code":-32601,"message":"未知MethodMissing"},"id":3,"jsonrpc":"2.0"}`},
+		{`{"jsonrpc":"2.0","method":"rpcModel.Add","params":[1,2],"id":4}`, ``},
+		{`{not json`, ``},
+	} {
+		conn.Write([]byte(c.req + "\n"))
+		got := readLine(t, conn, br)
+		if c.want == "" {
+			if _, ok := got["error"]; !ok {
+				t.Fatalf("%s: want error, got %v", c.req, got)
+			}
+			continue
+		}
+		js, _ := json.Marshal(got)
+		if string(js) != c.want {
+			t.Fatalf("%s:\n got %s\nwant %s", c.req, js, c.want)
+		}
+	}
+
+	//通知没有回复，处理方法发送的Data以通知的形式到达
+	conn.Write([]byte(`{"jsonrpc":"2.0","method":"rpcModel.Notify","params":{"msg":"hi"}}` + "\n"))
+	if msg := <-r.notified; msg != "hi" {
+		t.Fatalf("got %q", msg)
+	}
+	got := readLine(t, conn, br)
+	if got["method"] != "peer.Notified" || got["id"] != nil {
+		t.Fatalf("notification %v", got)
+	}
+	if _, err := r.m.OpenStream(); !errors.Is(err, ErrJSONRPCPeer) {
+		t.Fatalf("line mode stream: %v", err)
+	}
+}
+
+func TestJSONRPCBatch(t *testing.T) {
+	_, conn := rpcServer(t)
+	br := bufio.NewReader(conn)
+	conn.Write([]byte(`[{"jsonrpc":"2.0","method":"rpcModel.Add","params":{"a":"1","b":"2"},"id":1},` +
+		`{"jsonrpc":"2.0","method":"rpcModel.Notify","params":{"msg":"x"}},` +
+		`{"jsonrpc":"2.0","method":"nodot","id":2},1]` + "\n"))
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	line, err := br.ReadBytes('\n')
+	for err == nil && json.Valid(line) && line[0] == '{' {
+		//跳过Notify发出的通知
+		line, err = br.ReadBytes('\n')
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	var resps []rpcResponse
+	if err := json.Unmarshal(line, &resps); err != nil {
+		t.Fatalf("%v: %s", err, line)
+	}
+	if len(resps) != 3 {
+		t.Fatalf("got %d responses: %s", len(resps), line)
+	}
+	byID := make(map[string]rpcResponse)
+	for _, resp := range resps {
+		byID[string(resp.ID)] = resp
+	}
+	if string(byID["1"].Result) != "3" {
+		t.Fatalf("id 1: %s", line)
+	}
+	if byID["2"].Error == nil || byID["2"].Error.Code != RPCMethodNotFound {
+		t.Fatalf("id 2: %s", line)
+	}
+	if byID["null"].Error == nil || byID["null"].Error.Code != RPCInvalidRequest {
+		t.Fatalf("invalid entry: %s", line)
+	}
+}
+
+func TestJSONRPCFramed(t *testing.T) {
+	_, conn := rpcServer(t)
+	f, _ := EncodeFrame(RPC_TAG, []byte(`{"jsonrpc":"2.0","method":"rpcModel.Add","params":{"a":"2","b":"3"},"id":7}`))
+	conn.Write(f)
+	tag, raw, err := NewDecoder(conn).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag != RPC_TAG || string(raw) != `{"jsonrpc":"2.0","result":5,"id":7}` {
+		t.Fatalf("got %s %s", tag, raw)
+	}
+}
+
+//原生协议的对端发来rpc|帧后，本端仍以原生协议发送
+func TestJSONRPCFirstFrame(t *testing.T) {
+	r, conn := rpcServer(t)
+	d := NewDecoder(conn)
+	notify := func() {
+		data := NewData()
+		data.Model = "rpcModel"
+		data.Method = "Notify"
+		data.Args["msg"] = []byte("hi")
+		js, _ := json.Marshal(data)
+		f, _ := EncodeFrame(TAG, js)
+		conn.Write(f)
+		<-r.notified
+		if tag, raw, err := d.Next(); err != nil || tag != TAG {
+			t.Fatalf("got %s %s %v", tag, raw, err)
+		}
+	}
+	notify()
+	f, _ := EncodeFrame(RPC_TAG, []byte(`{"jsonrpc":"2.0","method":"rpcModel.Add","params":{"a":"2","b":"3"},"id":7}`))
+	conn.Write(f)
+	if tag, raw, err := d.Next(); err != nil || tag != RPC_TAG || string(raw) != `{"jsonrpc":"2.0","result":5,"id":7}` {
+		t.Fatalf("got %s %s %v", tag, raw, err)
+	}
+	notify()
+	if r.m.JSONRPC() {
+		t.Fatal("native peer switched to JSON-RPC")
+	}
+}
+
+//不是合法UTF-8的参数与结果返回错误，而不是被替换为U+FFFD
+func TestJSONRPCBinary(t *testing.T) {
+	id := []byte{0xff, 0xfe, 0x00, 0x01}
+	if _, err := rpcParams(map[string][]byte{"requestId": id}); !errors.Is(err, ErrEncodeFail) {
+		t.Fatalf("params: %v", err)
+	}
+	if _, err := rpcResult(id); !errors.Is(err, ErrEncodeFail) {
+		t.Fatalf("result: %v", err)
+	}
+	if params, err := rpcParams(map[string][]byte{"msg": []byte("你好")}); err != nil || params["msg"] != "你好" {
+		t.Fatalf("text params: %v %v", params, err)
+	}
+
+	r, conn := rpcServer(t)
+	br := bufio.NewReader(conn)
+	conn.Write([]byte(`{"jsonrpc":"2.0","method":"rpcModel.Add","params":{"a":"1","b":"2"},"id":1}` + "\n"))
+	readLine(t, conn, br)
+	data := NewData()
+	data.Model = "peer"
+	data.Method = "Binary"
+	data.Args["requestId"] = id
+	if err := r.m.Write(data); !errors.Is(err, ErrEncodeFail) {
+		t.Fatalf("write: %v", err)
+	}
+}
@@ -0,0 +1,384 @@
+package tcpmvc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+//流帧标志位
+const (
+	streamOpen      byte = 1 << iota //打开流
+	streamData                       //流数据
+	streamClose                      //关闭流，对端读取到io.EOF
+	streamReset                      //重置流，对端读写均返回错误
+	streamWindow                     //窗口更新，数据为对端可继续发送的字节数(4)
+	streamInitiator byte = 0x80      //该帧由流的打开方发出
+)
+
+const (
+	streamHeader  = 5          //流帧头：流id(4)+标志位(1)
+	streamChunk   = 16 * 1024  //单帧最大流数据，大块数据拆分后发送，避免阻塞同一连接上的其他流
+	acceptBacklog = 64         //等待AcceptStream的流数量上限
+	maxUnclaimed  = 64         //对端打开、尚未取走的流数量上限，超过时重置新打开的流，限制未读数据占用的内存
+	initialWindow = 256 * 1024 //每个流的接收窗口，对端最多发送这么多未被读取的数据
+)
+
+//Mvc连接内的一个逻辑流，实现io.ReadWriteCloser
+//多个流共用同一个TCP连接，各自以流id区分
+//每个流有独立的接收窗口，接收方读取得慢时发送方的Write会阻塞，而不会占满内存或阻塞整个连接
+type Stream struct {
+	id    uint32
+	local bool //是否由本端打开
+	m     *Mvc
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	buf     bytes.Buffer //已收到、未读取的数据
+	rclosed bool         //对端已关闭
+	wclosed bool         //本端已关闭
+	claimed bool         //已被AcceptStream或Stream取走
+	err     error        //流被重置或连接已断开
+
+	sendWindow uint32 //对端还能接收的字节数
+	consumed   uint32 //已读取、尚未通过窗口更新告知对端的字节数
+}
+
+func newStream(m *Mvc, id uint32, local bool) *Stream {
+	s := &Stream{id: id, local: local, m: m, sendWindow: initialWindow}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+//本端与对端的流id各自独立分配，以打开方区分
+func streamKey(id uint32, local bool) uint64 {
+	if local {
+		return uint64(id)<<1 | 1
+	}
+	return uint64(id) << 1
+}
+
+//流id，对端可通过Mvc.Stream(id)取得同一个流
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+func (s *Stream) Read(p []byte) (int, error) {
+	s.mu.Lock()
+	for {
+		if s.err != nil {
+			s.mu.Unlock()
+			return 0, s.err
+		}
+		if s.wclosed {
+			s.mu.Unlock()
+			return 0, ErrStreamClosed
+		}
+		if s.buf.Len() > 0 {
+			break
+		}
+		if s.rclosed {
+			s.mu.Unlock()
+			return 0, io.EOF
+		}
+		s.cond.Wait()
+	}
+	n, _ := s.buf.Read(p)
+	//读取过半个窗口后再告知对端，减少窗口更新帧的数量
+	var update uint32
+	s.consumed += uint32(n)
+	if s.consumed >= initialWindow/2 && !s.rclosed {
+		update = s.consumed
+		s.consumed = 0
+	}
+	s.mu.Unlock()
+	if update > 0 {
+		inc := make([]byte, 4)
+		binary.LittleEndian.PutUint32(inc, update)
+		s.m.writeStreamFrame(s.id, s.local, streamWindow, inc)
+	}
+	return n, nil
+}
+
+//写入流数据，超过streamChunk或对端窗口的数据拆分为多帧发送
+//对端窗口用完时阻塞，直到对端读取数据后更新窗口
+func (s *Stream) Write(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		size, err := s.reserve(len(p) - n)
+		if err != nil {
+			return n, err
+		}
+		err = s.m.writeStreamFrame(s.id, s.local, streamData, p[n:n+size])
+		if err != nil {
+			return n, err
+		}
+		n += size
+	}
+	return n, nil
+}
+
+//等待对端窗口，返回本次可发送的字节数
+func (s *Stream) reserve(want int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for {
+		if s.err != nil {
+			return 0, s.err
+		}
+		if s.wclosed || s.rclosed {
+			return 0, ErrStreamClosed
+		}
+		if s.sendWindow > 0 {
+			break
+		}
+		s.cond.Wait()
+	}
+	size := want
+	if size > streamChunk {
+		size = streamChunk
+	}
+	if uint32(size) > s.sendWindow {
+		size = int(s.sendWindow)
+	}
+	s.sendWindow -= uint32(size)
+	return size, nil
+}
+
+//关闭流，对端读完已发送的数据后得到io.EOF
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	if s.wclosed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.wclosed = true
+	err := s.err
+	done := s.rclosed || s.err != nil
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	if done {
+		s.m.removeStream(s)
+	}
+	if err != nil {
+		return nil
+	}
+	return s.m.writeStreamFrame(s.id, s.local, streamClose, nil)
+}
+
+//收到流数据，超出接收窗口说明对端未遵守流量控制，返回false
+func (s *Stream) push(p []byte) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.wclosed || s.err != nil {
+		return true
+	}
+	if s.buf.Len()+len(p) > initialWindow {
+		return false
+	}
+	s.buf.Write(p)
+	s.cond.Broadcast()
+	return true
+}
+
+//对端读取了数据，可以继续发送
+func (s *Stream) grow(inc uint32) {
+	s.mu.Lock()
+	s.sendWindow += inc
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+//对端关闭了流
+func (s *Stream) remoteClose() {
+	s.mu.Lock()
+	s.rclosed = true
+	done := s.wclosed
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	if done {
+		s.m.removeStream(s)
+	}
+}
+
+func (s *Stream) reset(err error) {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+}
+
+//标记流已被取走，已取走或已失效的流返回false
+func (s *Stream) claim() bool {
+	m := s.m
+	m.smu.Lock()
+	defer m.smu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.claimed || s.err != nil {
+		return false
+	}
+	s.claimed = true
+	if !s.local && m.streams[streamKey(s.id, false)] == s {
+		m.unclaimed--
+	}
+	return true
+}
+
+//打开一个新的流，对端通过AcceptStream或Stream(id)取得
+func (m *Mvc) OpenStream() (*Stream, error) {
+	if m.Legacy() {
+		return nil, ErrLegacyPeer
+	}
+	if atomic.LoadInt32(&m.rpc) == rpcLines {
+		return nil, ErrJSONRPCPeer
+	}
+	m.smu.Lock()
+	if m.streamErr != nil {
+		m.smu.Unlock()
+		return nil, m.streamErr
+	}
+	m.nextStream++
+	s := newStream(m, m.nextStream, true)
+	s.claimed = true
+	m.streams[streamKey(s.id, true)] = s
+	m.smu.Unlock()
+	err := m.writeStreamFrame(s.id, true, streamOpen, nil)
+	if err != nil {
+		m.removeStream(s)
+		return nil, err
+	}
+	return s, nil
+}
+
+//等待对端打开的下一个流，连接断开后返回错误
+func (m *Mvc) AcceptStream() (*Stream, error) {
+	for s := range m.accept {
+		if s.claim() {
+			return s, nil
+		}
+	}
+	return nil, ErrStreamClosed
+}
+
+//按id取得对端打开的流，用于对端在Data参数中告知流id的场景
+//对端的打开帧先于之后的Data到达，处理Data时流已经存在
+func (m *Mvc) Stream(id uint32) (*Stream, bool) {
+	m.smu.Lock()
+	s, ok := m.streams[streamKey(id, false)]
+	m.smu.Unlock()
+	if !ok || !s.claim() {
+		return nil, false
+	}
+	return s, true
+}
+
+func (m *Mvc) removeStream(s *Stream) {
+	m.smu.Lock()
+	defer m.smu.Unlock()
+	key := streamKey(s.id, s.local)
+	if m.streams[key] != s {
+		return
+	}
+	delete(m.streams, key)
+	s.mu.Lock()
+	if !s.local && !s.claimed {
+		m.unclaimed--
+	}
+	s.mu.Unlock()
+}
+
+//处理收到的流帧，在读取循环中调用，不能阻塞
+func (m *Mvc) handleStream(raw []byte) {
+	if len(raw) < streamHeader {
+		fmt.Printf("tcpmvc:%s:流帧长度%d\n", StatusDataLengthError, len(raw))
+		return
+	}
+	id := binary.LittleEndian.Uint32(raw)
+	flag := raw[4]
+	payload := raw[streamHeader:]
+	//由打开方发出的帧对应的是对端打开的流
+	local := flag&streamInitiator == 0
+	m.smu.Lock()
+	s, ok := m.streams[streamKey(id, local)]
+	if !ok {
+		if local || flag&streamOpen == 0 {
+			m.smu.Unlock()
+			if flag&(streamClose|streamReset) == 0 {
+				fmt.Printf("tcpmvc:%s:%d\n", StatusStreamUnknow, id)
+				go m.writeStreamFrame(id, local, streamReset, nil)
+			}
+			return
+		}
+		if m.unclaimed >= maxUnclaimed {
+			m.smu.Unlock()
+			fmt.Printf("tcpmvc:未取走的流超过%d个，重置流:%d\n", maxUnclaimed, id)
+			go m.writeStreamFrame(id, false, streamReset, nil)
+			return
+		}
+		s = newStream(m, id, false)
+		m.streams[streamKey(id, false)] = s
+		m.unclaimed++
+		m.smu.Unlock()
+		select {
+		case m.accept <- s:
+		default:
+			//等待队列已满，仍可通过Stream(id)取得
+		}
+	} else {
+		m.smu.Unlock()
+	}
+	if len(payload) > 0 && flag&streamData != 0 && !s.push(payload) {
+		fmt.Printf("tcpmvc:%s:%d\n", StatusStreamOverflow, id)
+		s.reset(ErrStreamOverflow)
+		m.removeStream(s)
+		go m.writeStreamFrame(id, s.local, streamReset, nil)
+		return
+	}
+	if flag&streamWindow != 0 && len(payload) >= 4 {
+		s.grow(binary.LittleEndian.Uint32(payload))
+	}
+	if flag&streamClose != 0 {
+		s.remoteClose()
+	}
+	if flag&streamReset != 0 {
+		s.reset(ErrStreamReset)
+		m.removeStream(s)
+	}
+}
+
+//连接断开，所有流读写均返回err
+func (m *Mvc) closeStreams(err error) {
+	if err == nil {
+		err = ErrStreamClosed
+	}
+	m.smu.Lock()
+	if m.streamErr != nil {
+		m.smu.Unlock()
+		return
+	}
+	m.streamErr = err
+	streams := m.streams
+	m.streams = make(map[uint64]*Stream)
+	m.unclaimed = 0
+	close(m.accept)
+	m.smu.Unlock()
+	for _, s := range streams {
+		s.reset(err)
+	}
+}
+
+func (m *Mvc) writeStreamFrame(id uint32, local bool, flag byte, data []byte) error {
+	if local {
+		flag |= streamInitiator
+	}
+	var head [streamHeader]byte
+	binary.LittleEndian.PutUint32(head[:], id)
+	head[4] = flag
+	return m.writeFrame(STREAM_TAG, head[:], data)
+}
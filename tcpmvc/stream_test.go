@@ -0,0 +1,270 @@
+package tcpmvc
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//建立一对通过本地TCP相连的Mvc
+func tcpPair(t *testing.T) (*Mvc, *Mvc) {
+	l, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	ch := make(chan *net.TCPConn)
+	go func() {
+		c, err := l.AcceptTCP()
+		if err != nil {
+			t.Error(err)
+		}
+		ch <- c
+	}()
+	c1, err := net.DialTCP("tcp", nil, l.Addr().(*net.TCPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c2 := <-ch
+	a, b := New(c1), New(c2)
+	go a.StartHandle()
+	go b.StartHandle()
+	t.Cleanup(func() {
+		c1.Close()
+		c2.Close()
+	})
+	return a, b
+}
+
+func TestStreamEcho(t *testing.T) {
+	a, b := tcpPair(t)
+	go func() {
+		for {
+			s, err := b.AcceptStream()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(s, s)
+				s.Close()
+			}()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			s, err := a.OpenStream()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			want := bytes.Repeat([]byte{byte(i)}, 3*streamChunk+i)
+			go func() {
+				s.Write(want)
+			}()
+			got := make([]byte, len(want))
+			_, err = io.ReadFull(s, got)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("stream %d: data mismatch", s.ID())
+			}
+			s.Close()
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestStreamCloseEOF(t *testing.T) {
+	a, b := tcpPair(t)
+	s, err := a.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Write([]byte("hello"))
+	s.Close()
+
+	r, err := b.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("got %q", got)
+	}
+	if _, err := r.Write([]byte("x")); err == nil {
+		t.Fatal("write after remote close should fail")
+	}
+	r.Close()
+}
+
+func TestStreamByID(t *testing.T) {
+	a, b := tcpPair(t)
+	s, err := a.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Write([]byte("ping"))
+	var r *Stream
+	for r == nil {
+		r, _ = b.Stream(s.ID())
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "ping" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+	if _, ok := b.Stream(s.ID()); ok {
+		t.Fatal("stream claimed twice")
+	}
+}
+
+func TestStreamFlowControl(t *testing.T) {
+	a, b := tcpPair(t)
+	slow, err := a.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written int64
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		piece := make([]byte, 1024)
+		for i := 0; i < 4*initialWindow/len(piece); i++ {
+			if _, err := slow.Write(piece); err != nil {
+				t.Error(err)
+				return
+			}
+			atomic.AddInt64(&written, int64(len(piece)))
+		}
+		slow.Close()
+	}()
+	r, err := b.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	//等到对端收满一个窗口、发送方的窗口用完，此后接收方不读取时Write不能再写出数据
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		slow.mu.Lock()
+		window := slow.sendWindow
+		slow.mu.Unlock()
+		r.mu.Lock()
+		buffered := r.buf.Len()
+		r.mu.Unlock()
+		if window == 0 && buffered == initialWindow {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("writer not blocked: window %d, receiver buffered %d", window, buffered)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&written); n > initialWindow {
+		t.Fatalf("wrote %d bytes without the receiver reading, window is %d", n, initialWindow)
+	}
+
+	//慢的流不影响同一连接上的其他流
+	fast, err := a.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast.Write([]byte("fast"))
+	fr, err := b.AcceptStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(fr, buf); err != nil || string(buf) != "fast" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if len(got) != 4*initialWindow {
+		t.Fatalf("read %d bytes, want %d", len(got), 4*initialWindow)
+	}
+}
+
+//对端打开而本端一直不取走的流超过上限时，新打开的流被重置，取走一个后可以再打开
+func TestStreamUnclaimedLimit(t *testing.T) {
+	a, b := tcpPair(t)
+	//等待本端收到重置或超时
+	resetBy := func(s *Stream) error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := s.Read(make([]byte, 1))
+			done <- err
+		}()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(2 * time.Second):
+			s.reset(ErrStreamClosed)
+			return nil
+		}
+	}
+
+	var opened []*Stream
+	for i := 0; i < maxUnclaimed+8; i++ {
+		s, err := a.OpenStream()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Write([]byte("unclaimed")); err != nil {
+			t.Fatal(err)
+		}
+		opened = append(opened, s)
+	}
+	for _, s := range opened[maxUnclaimed:] {
+		if err := resetBy(s); !errors.Is(err, ErrStreamReset) {
+			t.Fatalf("stream %d over the limit: %v", s.ID(), err)
+		}
+	}
+	b.smu.Lock()
+	unclaimed, total := b.unclaimed, len(b.streams)
+	b.smu.Unlock()
+	if unclaimed != maxUnclaimed || total != maxUnclaimed {
+		t.Fatalf("unclaimed %d, streams %d", unclaimed, total)
+	}
+
+	//取走后不再计数，又可以打开新的流
+	r, ok := b.Stream(opened[0].ID())
+	if !ok {
+		t.Fatal("stream under the limit not kept")
+	}
+	buf := make([]byte, len("unclaimed"))
+	if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "unclaimed" {
+		t.Fatalf("got %q, %v", buf, err)
+	}
+	s, err := a.OpenStream()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Write([]byte("again"))
+	var again *Stream
+	deadline := time.Now().Add(2 * time.Second)
+	for again == nil {
+		again, _ = b.Stream(s.ID())
+		if again == nil && time.Now().After(deadline) {
+			t.Fatal("stream not accepted after one was claimed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
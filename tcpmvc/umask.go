@@ -0,0 +1,21 @@
+//go:build !windows
+
+package tcpmvc
+
+import (
+	"sync"
+	"syscall"
+)
+
+var umaskMu sync.Mutex //umask是进程级的设置，串行化本包的修改
+
+//在额外屏蔽组与其他用户权限的umask下调用fn，期间创建的socket文件只有所有者可以连接
+//同时创建的其他文件也只会少了组与其他用户的权限，不会多出权限
+func withPrivateUmask(fn func() error) error {
+	umaskMu.Lock()
+	defer umaskMu.Unlock()
+	old := syscall.Umask(0077)
+	syscall.Umask(old | 0077)
+	defer syscall.Umask(old)
+	return fn()
+}
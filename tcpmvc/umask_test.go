@@ -0,0 +1,51 @@
+//go:build !windows
+
+package tcpmvc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+//进程的umask很宽松时，socket创建时也只有所有者的权限，ListenUnix返回后恢复原umask
+func TestListenUnixUmask(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcpmvc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := syscall.Umask(0)
+	defer syscall.Umask(old)
+
+	var created os.FileMode
+	err = withPrivateUmask(func() error {
+		f, err := os.OpenFile(filepath.Join(dir, "probe"), os.O_CREATE|os.O_WRONLY, 0666)
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		f.Close()
+		if err == nil {
+			created = fi.Mode().Perm()
+		}
+		return err
+	})
+	if err != nil || created != 0600 {
+		t.Fatalf("created with %v %v", created, err)
+	}
+
+	l, err := ListenUnix(filepath.Join(dir, "mvc.sock"), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if fi, err := os.Stat(filepath.Join(dir, "mvc.sock")); err != nil || fi.Mode().Perm() != 0666 {
+		t.Fatalf("perm %v %v", fi, err)
+	}
+	if got := syscall.Umask(0); got != 0 {
+		t.Fatalf("umask not restored: %#o", got)
+	}
+}
@@ -0,0 +1,6 @@
+package tcpmvc
+
+//Windows没有umask，socket文件的访问由ACL控制
+func withPrivateUmask(fn func() error) error {
+	return fn()
+}
@@ -0,0 +1,50 @@
+package tcpmvc
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+)
+
+//Unix socket文件的默认权限，只有同一用户或同一组的进程可以连接
+const DefaultUnixPerm os.FileMode = 0660
+
+//在Unix socket上监听本机的对端，接受的连接可直接交给New
+//path已存在时，若仍有进程在监听则返回错误，否则视为上次异常退出留下的文件并删除
+//perm为0时使用DefaultUnixPerm；socket先以只有所有者可连接的权限创建，再改为perm，
+//其他用户不能在改权限之前连接；监听关闭时删除socket文件
+func ListenUnix(path string, perm os.FileMode) (*net.UnixListener, error) {
+	if perm == 0 {
+		perm = DefaultUnixPerm
+	}
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s已存在且不是socket文件", path)
+		}
+		c, err := net.DialTimeout("unix", path, time.Second)
+		if err == nil {
+			c.Close()
+			return nil, fmt.Errorf("%s已有进程在监听", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	var l *net.UnixListener
+	err := withPrivateUmask(func() (err error) {
+		l, err = net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,82 @@
+package tcpmvc
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListenUnix(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tcpmvc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "mvc.sock")
+
+	l, err := ListenUnix(path, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode().Perm() != 0600 {
+		t.Fatalf("perm %v %v", fi, err)
+	}
+	if _, err := ListenUnix(path, 0); err == nil {
+		t.Fatal("listened twice on the same path")
+	}
+
+	//上面的检查也连接过一次，循环接受
+	go func(l *net.UnixListener) {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			b := New(c)
+			b.Include(&rpcModel{m: b, notified: make(chan string, 1)})
+			go b.StartHandle()
+		}
+	}(l)
+	c, err := net.Dial("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := New(c)
+	go a.StartHandle()
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	data := NewData()
+	data.Model = "rpcModel"
+	data.Method = "Add"
+	data.Args["a"] = []byte("1")
+	data.Args["b"] = []byte("2")
+	if result, err := a.Call(ctx, data); err != nil || string(result) != "3" {
+		t.Fatalf("got %s %v", result, err)
+	}
+
+	//上次异常退出留下的socket文件被删除后重新监听
+	l.SetUnlinkOnClose(false)
+	l.Close()
+	time.Sleep(10 * time.Millisecond)
+	l, err = ListenUnix(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Close()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("socket file left after Close: %v", err)
+	}
+
+	if err := ioutil.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ListenUnix(path, 0); err == nil {
+		t.Fatal("removed a regular file")
+	}
+}
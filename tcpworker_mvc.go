@@ -0,0 +1,33 @@
+// Code generated by mvcgen. DO NOT EDIT.
+
+package main
+
+import (
+	"pointTest/tcpProxy/tcpmvc"
+)
+
+// tcpWorker可由消息调用的方法，方法改名或删除后下面的断言无法编译，需重新运行go generate
+type tcpWorkerServer interface {
+	HttpResponse(map[string][]byte)
+	ListRegistrations() []string
+	Message(map[string][]byte)
+	Register(map[string][]byte) error
+	Unregister(map[string][]byte) error
+}
+
+var _ tcpWorkerServer = (*tcpWorker)(nil)
+
+// 直接注册tcpWorker可由消息调用的方法，不经过反射，Include时自动调用
+func (impl *tcpWorker) RegisterHandlers(m *tcpmvc.Mvc) {
+	m.Handle("tcpWorker", "HttpResponse", impl.HttpResponse)
+	m.HandleResult("tcpWorker", "ListRegistrations", func(data *tcpmvc.Data) (interface{}, error) {
+		return impl.ListRegistrations(), nil
+	})
+	m.Handle("tcpWorker", "Message", impl.Message)
+	m.HandleResult("tcpWorker", "Register", func(data *tcpmvc.Data) (interface{}, error) {
+		return nil, impl.Register(data.Args)
+	})
+	m.HandleResult("tcpWorker", "Unregister", func(data *tcpmvc.Data) (interface{}, error) {
+		return nil, impl.Unregister(data.Args)
+	})
+}